@@ -23,12 +23,26 @@ func (s *Server) NewGame(firstBlock string) (*GameState, error) {
   err := s.SignedRequest("/Games", Request{
     Author: s.Author(),
     FirstBlock: firstBlock,
-    Timestamp: time.Now().Format(time.RFC3339),
+    Timestamp: s.Now().Format(time.RFC3339),
   }, &res)
   if err != nil { return nil, err }
   return &res, nil
 }
 
+/* Check that a game key, which is also used as a file name, is made of
+   URL-safe characters and cannot name another directory. */
+func CheckGameKey(key string) error {
+  if key == "" || key[0] == '.' {
+    return errors.Errorf("bad game key %q", key)
+  }
+  for _, c := range key {
+    ok := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+      c == '-' || c == '_' || c == '.' || c == '='
+    if !ok { return errors.Errorf("bad game key %q", key) }
+  }
+  return nil
+}
+
 func (s *Server) ShowGame(gameKey string) (*GameState, error) {
   type Response struct {
     Game GameState `json:"game"`
@@ -105,8 +119,9 @@ func (s *Server) CloseRound(gameKey string, currentBlock string) ([]byte, error)
   return res.Commands, nil
 }
 
-func (s *Server) Ping(gameKey string) (io.ReadCloser, error) {
-  var err error
+func (s *Server) Ping(gameKey string) (rc io.ReadCloser, err error) {
+  start := time.Now()
+  defer func() { observeRequest("/Games", start, err) }()
   type Request struct {
     Author string `json:"author"`
     GameKey string `json:"gameKey"`
@@ -118,7 +133,7 @@ func (s *Server) Ping(gameKey string) (io.ReadCloser, error) {
     Author: s.Author(),
     Action: "ping",
     GameKey: gameKey,
-    Timestamp: unixMillisTimestamp(),
+    Timestamp: s.unixMillisTimestamp(),
   })
   if err != nil { return nil, errors.Errorf("malformed message: %s", err) }
   bsReq, err := signing.Sign(s.teamKeyPair.Private, s.ApiKey, b.Bytes())
@@ -151,12 +166,12 @@ func (s *Server) Pong(gameKey string, payload string, botIds []uint32) error {
     Action: "pong",
     BotIds: botIds,
     Payload: payload,
-    Timestamp: unixMillisTimestamp(),
+    Timestamp: s.unixMillisTimestamp(),
   }, nil)
   if err != nil { return err }
   return nil
 }
 
-func unixMillisTimestamp() string {
-  return strconv.FormatInt(time.Now().UnixNano() / 1000000, 10)
+func (s *Server) unixMillisTimestamp() string {
+  return strconv.FormatInt(s.Now().UnixNano() / 1000000, 10)
 }
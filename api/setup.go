@@ -3,7 +3,6 @@ package api
 
 import (
   "fmt"
-  "errors"
 )
 
 func (s *Server) AddSetupBlock(parentHash string, params map[string]interface{}) (string, error) {
@@ -24,8 +23,7 @@ func (s *Server) AddSetupBlock(parentHash string, params map[string]interface{})
   err = s.PlainRequest(path, &req, &res)
   if err != nil { return "", err }
   if res.Error != "" {
-    fmt.Printf("Error during setup:\n%s\n%s\n", res.Error, res.Details)
-    return "", errors.New("error during setup")
+    return "", fmt.Errorf("Error during setup:\n%s\n%s", res.Error, res.Details)
   }
   return res.Hash, nil
 }
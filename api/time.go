@@ -9,7 +9,8 @@ func (s *Server) GetTime() (res time.Time, err error) {
   var timeStr string
   err = s.GetRequest("/Time", &timeStr)
   if err != nil { return }
-  t, err := time.Parse(time.RFC3339, timeStr)
+  /* Keep the fractional seconds, if the server sends them. */
+  t, err := time.Parse(time.RFC3339Nano, timeStr)
   if err != nil { return }
   return t, nil
 }
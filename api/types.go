@@ -63,3 +63,24 @@ type CommandBlock struct {
   AnyBlock
   Commands [][]PlayerCommand `json:"commands"`
 }
+
+/* Contents of a block's state.json (only the fields used by tc-node).
+   This is specific to task1, whose state is produced by the task's code on
+   the server, not by this repository.  round is also read by block_store;
+   players and map have no published schema, and their layout below is
+   not checked against the task's code.  Missing fields decode as empty,
+   and the renderer then draws nothing for them. */
+type TaskState struct {
+  Round uint64 `json:"round"`
+  Players []PlayerState `json:"players"`
+  /* map_side rows of map_side cells, each holding the rank of the player
+     who owns the cell, or 0. */
+  Map [][]uint32 `json:"map"`
+}
+
+type PlayerState struct {
+  Rank uint32 `json:"rank"`
+  Score int64 `json:"score"`
+  Row int `json:"row"`
+  Col int `json:"col"`
+}
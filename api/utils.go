@@ -4,12 +4,14 @@ package api
 import (
   "bytes"
   "encoding/json"
+  stderrors "errors"
   "github.com/go-errors/errors"
-  "fmt"
   "io"
   "net/http"
-  "os"
+  "strings"
+  "time"
   "tezos-contests.izibi.com/backend/signing"
+  "tezos-contests.izibi.com/tc-node/metrics"
 )
 
 type Server struct {
@@ -17,8 +19,7 @@ type Server struct {
   ApiKey string
   teamKeyPair *signing.KeyPair
   client *http.Client
-  LastError string /* last error */
-  LastDetails string /* details of last error */
+  Now func() time.Time /* server clock, used for request timestamps */
 }
 
 type ServerResponse struct {
@@ -27,12 +28,30 @@ type ServerResponse struct {
   Details string `json:"details"`
 }
 
+/* Error reported by the server in its response to a request. */
+type ApiError struct {
+  Message string
+  Details string
+}
+
+func (e *ApiError) Error() string {
+  return e.Message
+}
+
+/* The server's error, if err is (or wraps) one. */
+func AsApiError(err error) (*ApiError, bool) {
+  var apiErr *ApiError
+  ok := stderrors.As(err, &apiErr)
+  return apiErr, ok
+}
+
 func New (base string, apiKey string, teamKeyPair *signing.KeyPair) (*Server) {
   return &Server{
     Base: base,
     ApiKey: apiKey,
     teamKeyPair: teamKeyPair,
     client: new(http.Client),
+    Now: time.Now,
   }
 }
 
@@ -40,7 +59,21 @@ func (s *Server) Author() string {
   return "@" + s.teamKeyPair.Public
 }
 
+/* Label requests by the first component of their path (/Games, /Blocks...),
+   so that game keys and hashes do not create new series. */
+func endpointLabel(path string) string {
+  parts := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)
+  return "/" + parts[0]
+}
+
+func observeRequest(path string, start time.Time, err error) {
+  metrics.ApiRequestDuration.WithLabelValues(endpointLabel(path),
+    metrics.Outcome(err)).Observe(time.Since(start).Seconds())
+}
+
 func (s *Server) GetRequest(path string, result interface{}) (err error) {
+  start := time.Now()
+  defer func() { observeRequest(path, start, err) }()
   var req *http.Request
   req, err = http.NewRequest("GET", s.Base + path, nil)
   if err != nil { err = errors.Wrap(err, 0); return }
@@ -57,14 +90,14 @@ func (s *Server) GetRequest(path string, result interface{}) (err error) {
   sr := ServerResponse{result, "", ""}
   err = json.NewDecoder(resp.Body).Decode(&sr)
   if sr.Error != "" {
-    s.LastError = sr.Error
-    s.LastDetails = sr.Details
-    return errors.New("API error")
+    return &ApiError{sr.Error, sr.Details}
   }
   return
 }
 
 func (s *Server) postRequest(path string, body io.Reader, result interface{}) (err error) {
+  start := time.Now()
+  defer func() { observeRequest(path, start, err) }()
   var resp *http.Response
   resp, err = http.Post(s.Base + path,
     "application/json; charset=utf-8", body)
@@ -72,8 +105,7 @@ func (s *Server) postRequest(path string, body io.Reader, result interface{}) (e
   if resp.StatusCode < 200 || resp.StatusCode >= 299 {
     buf := new(bytes.Buffer)
     buf.ReadFrom(resp.Body)
-    fmt.Fprintln(os.Stderr, buf.String()) // XXX output to stderr
-    err = errors.New(resp.Status)
+    err = errors.Errorf("%s: %s", resp.Status, strings.TrimSpace(buf.String()))
     return
   }
   if resp.StatusCode == 200 {
@@ -90,8 +122,6 @@ func (s *Server) PlainRequest(path string, msg interface{}, result interface{})
 }
 
 func (s *Server) SignedRequest(path string, msg interface{}, result interface{}) error {
-  s.LastError = ""
-  s.LastDetails = ""
   if s.teamKeyPair == nil {
     return errors.Errorf("team keypair is missing")
   }
@@ -104,9 +134,7 @@ func (s *Server) SignedRequest(path string, msg interface{}, result interface{})
   err = s.postRequest(path, bytes.NewReader(bs), &resp)
   if err != nil { return errors.Errorf("failed to contact API: %s", err) }
   if resp.Error != "" {
-    s.LastError = resp.Error
-    s.LastDetails = resp.Details
-    return errors.New("API error")
+    return &ApiError{resp.Error, resp.Details}
   }
   return nil
 }
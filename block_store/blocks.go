@@ -18,6 +18,7 @@ import (
   "github.com/fatih/color"
   "github.com/go-errors/errors"
   "github.com/json-iterator/go"
+  "tezos-contests.izibi.com/tc-node/metrics"
 )
 
 var noticeFmt = color.New(color.FgHiBlack)
@@ -68,15 +69,8 @@ func (st *Store) Get(hash string) (res *Block, err error) {
   if res != nil { return res, nil }
   /* If the hash is in the index, name the blockDir with the round number.
      Otherwise, name the blockdir with the hash (maybe renamed later). */
-  var blockDir string
   var round uint64
-  var ok bool
-  round, ok = st.Index.GetRoundByHash(hash)
-  if ok {
-    blockDir = filepath.Join(st.BlocksDir, strconv.FormatUint(round, 10))
-  } else {
-    blockDir = filepath.Join(st.BlocksDir, hash)
-  }
+  var blockDir = st.BlockDir(hash)
   /* Create the blockDir, fetch and unzip the block. */
   err = os.MkdirAll(blockDir, os.ModePerm)
   if err != nil { err = errors.Errorf("failed to create '%s'", blockDir); return }
@@ -129,6 +123,35 @@ func (s *Store) GetChain(firstBlock string, lastBlock string) (err error) {
   return
 }
 
+/* Return the directory holding the (possibly not yet fetched) block. */
+func (st *Store) BlockDir(hash string) string {
+  round, ok := st.Index.GetRoundByHash(hash)
+  if ok {
+    return filepath.Join(st.BlocksDir, strconv.FormatUint(round, 10))
+  }
+  return filepath.Join(st.BlocksDir, hash)
+}
+
+/* Decode the block.json file of a block that is in the store. */
+func (st *Store) ReadBlock(hash string, v interface{}) error {
+  blockPath := filepath.Join(st.BlockDir(hash), "block.json")
+  bs, err := ioutil.ReadFile(blockPath)
+  if err != nil { return errors.Errorf("failed to read '%s'", blockPath) }
+  err = json.Unmarshal(bs, v)
+  if err != nil { return errors.Errorf("bad block '%s': %s", blockPath, err) }
+  return nil
+}
+
+/* Decode the state.json file of a block that is in the store. */
+func (st *Store) ReadState(hash string, v interface{}) error {
+  statePath := filepath.Join(st.BlockDir(hash), "state.json")
+  bs, err := ioutil.ReadFile(statePath)
+  if err != nil { return errors.Errorf("failed to read '%s'", statePath) }
+  err = json.Unmarshal(bs, v)
+  if err != nil { return errors.Errorf("bad state '%s': %s", statePath, err) }
+  return nil
+}
+
 func (s *Store) readRoundNumber(hash string) (uint64, error) {
   statePath := filepath.Join(s.BlocksDir, hash, "state.json")
   b, err := ioutil.ReadFile(statePath)
@@ -154,6 +177,8 @@ func (s *Store) fetch(hash string, dest string) (err error) {
 
   bs, err := ioutil.ReadAll(resp.Body)
   if err != nil { err = errors.Wrap(err, 0); return }
+  metrics.BlockDownloads.Inc()
+  metrics.BlockBytes.Add(float64(len(bs)))
   r, err := zip.NewReader(bytes.NewReader(bs), int64(len(bs)))
   if err != nil { err = errors.Wrap(err, 0); return }
 
@@ -63,6 +63,11 @@ func (idx *Index) Add(hash string, round uint64) (err error) {
   return nil
 }
 
+/* Number of blocks in the index. */
+func (idx *Index) Len() int {
+  return len(idx.roundByHash)
+}
+
 func (idx *Index) GetRoundByHash(hash string) (uint64, bool) {
   val, ok := idx.roundByHash[hash]
   return val, ok
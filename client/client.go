@@ -3,9 +3,12 @@ package client
 
 import (
   "io/ioutil"
+  "sync"
   "time"
   "tezos-contests.izibi.com/tc-node/api"
   "tezos-contests.izibi.com/tc-node/block_store"
+  "tezos-contests.izibi.com/tc-node/history"
+  "tezos-contests.izibi.com/tc-node/notify"
   "tezos-contests.izibi.com/backend/signing"
 )
 
@@ -14,15 +17,30 @@ type Client interface {
   GetTimeStats() (*TimeStats, error)
   Connect() (<-chan interface{}, error)
   Worker() (chan<- Command, chan<- Command)
+  RequestSync()
 
   LoadGame() error
   NewGame(taskParams map[string]interface{}) error
   JoinGame(gameKey string) error
+  WatchGame(gameKey string) error
+  ServerTime() time.Time
+  StartClockSync(interval time.Duration, threshold time.Duration)
+
   Game() *api.GameState
+  RoundDeadline() (time.Time, bool)
+  Phase() GamePhase
+  BotRanks() []uint32
+  Snapshot() Snapshot
+  BotResults() []BotFeedback
+  SetBotEnabled(botId uint32, enabled bool)
+  BotEnabled(botId uint32) bool
+  PingResults() []PingResult
+  Close()
 
 }
 
 type Notifier interface {
+  notify.Logger
   Partial(msg string)
   Partialf(format string, a ...interface{})
   Final(msg string)
@@ -38,22 +56,64 @@ type client struct {
   task string
   remote *api.Server
   store *block_store.Store
+  history *history.History
+  workspace Workspace
+  watching bool /* following a game as a spectator, without bots */
   teamKeyPair *signing.KeyPair
+  /* game, bots and botRanks are only written by the worker (or before it
+     starts), through setGame, setBots and setBotRanks; other goroutines
+     read them with Snapshot. */
   bots []BotConfig
+  stateMutex sync.Mutex
+  stderrConfig StderrConfig
   botRanks []uint32
   botsRegistered bool
   game *api.GameState
   gameChannel string
-  eventsKey string
+  stream *EventStream
+  sink *eventSink
+  done chan struct{} /* closed by Close */
+  closeOnce sync.Once
   eventChannel chan interface{}
   workerRunning bool
+  syncRequest chan struct{} /* a pending SyncThenSendCommands */
   notifier Notifier
   roundCommandsOk uint64
+  phase GamePhase
+  phaseMutex sync.Mutex
+  latestBlock string /* last block announced on the event stream */
+  latestBlockMutex sync.Mutex
+  clock *Clock
+  params *api.GameParams
+  paramsBlock string
+  trackedBlock string
+  players map[uint32]bool /* ranks of the players seen by the last ping */
+  submitted map[uint32]bool /* ranks of the players who sent commands */
+  roundSeq uint64
+  roundMutex sync.Mutex
+  botResults map[uint32]BotFeedback /* last run of each bot, by bot id */
+  pingResults []PingResult
+  resultsMutex sync.Mutex
+  disabledBots map[uint32]bool
+  botsMutex sync.Mutex
+}
+
+/* The game state as last published by the worker.  The values are never
+   modified in place, they are replaced. */
+type Snapshot struct {
+  Game *api.GameState
+  Bots []BotConfig
+  BotRanks []uint32
+  Deadline time.Time /* local time at which the round ends, zero if none */
 }
 
 type BotConfig struct {
   Id uint32 `yaml:"id"`
   Command string `yaml:"command"`
+  Input string `yaml:"input"` /* InputText (default) or InputJson */
+  /* Command run instead while the bot is disabled; a disabled bot without
+     a fallback sends no commands. */
+  Fallback string `yaml:"fallback"`
 }
 
 type TimeStats struct {
@@ -63,28 +123,36 @@ type TimeStats struct {
   Delta   time.Duration
 }
 
-func New(notifier Notifier, task string, remote *api.Server, store *block_store.Store, teamKeyPair *signing.KeyPair, bots []BotConfig) Client {
+func New(notifier Notifier, task string, remote *api.Server, store *block_store.Store, hist *history.History, workspace Workspace, teamKeyPair *signing.KeyPair, bots []BotConfig) Client {
   return &client{
     task: task,
     remote: remote,
     store: store,
+    history: hist,
+    workspace: workspace,
     teamKeyPair: teamKeyPair,
     bots: bots,
     notifier: notifier,
+    clock: NewClock(remote),
+    syncRequest: make(chan struct{}, 1),
   }
 }
 
+/* Estimate the server clock from a burst of samples. */
 func (c *client) GetTimeStats() (*TimeStats, error) {
   var err error
+  for i := 0; i < clockBurst; i++ {
+    err = c.clock.Sample()
+    if err != nil { return nil, err }
+  }
+  offset, rtt := c.clock.Estimate()
   localTime := time.Now()
-  var serverTime, serverTime2 time.Time
-  serverTime, err = c.remote.GetTime()
-  if err != nil { return nil, err }
-  serverTime2, err = c.remote.GetTime()
-  if err != nil { return nil, err }
-  latency := serverTime2.Sub(serverTime)
-  delta := serverTime.Sub(localTime) - latency
-  return &TimeStats{localTime, serverTime, latency, delta}, nil
+  return &TimeStats{localTime, localTime.Add(offset), rtt / 2, offset}, nil
+}
+
+/* Corrected server time. */
+func (c *client) ServerTime() time.Time {
+  return c.clock.Now()
 }
 
 func (cl *client) LoadGame() error {
@@ -115,10 +183,10 @@ func (cl *client) NewGame(taskParams map[string]interface{}) error {
   var impl string
   cl.notifier.Partial("Loading protocol")
   var b []byte
-  b, err = ioutil.ReadFile("protocol.mli")
+  b, err = ioutil.ReadFile(cl.workspace.Path(cl.workspace.ProtocolIntf))
   if err != nil { return err }
   intf = string(b)
-  b, err = ioutil.ReadFile("protocol.ml")
+  b, err = ioutil.ReadFile(cl.workspace.Path(cl.workspace.ProtocolImpl))
   if err != nil { return err }
   impl = string(b)
   if err != nil { return err }
@@ -132,8 +200,10 @@ func (cl *client) NewGame(taskParams map[string]interface{}) error {
   var game *api.GameState
   game, err = cl.remote.NewGame(setupHash)
   if err != nil { return err }
-  cl.game = game
+  cl.setGame(game)
   cl.gameChannel = "game:" + game.Key
+  cl.botsRegistered = false
+  cl.resetPhase()
   cl.notifier.Partial("Saving game state")
   err = cl.saveGame()
   cl.notifier.Partial("Clearing store")
@@ -153,9 +223,12 @@ func (cl *client) NewGame(taskParams map[string]interface{}) error {
 func (cl *client) JoinGame(gameKey string) error {
   var err error
   cl.notifier.Partial("Retrieving game state")
-  cl.game, err = cl.remote.ShowGame(gameKey)
+  game, err := cl.remote.ShowGame(gameKey)
   if err != nil { return err }
+  cl.setGame(game)
   cl.gameChannel = "game:" + cl.game.Key
+  cl.botsRegistered = false
+  cl.resetPhase()
   if err != nil { return err }
   // Subscribe to game events
   err = cl.subscribe(cl.gameChannel)
@@ -175,6 +248,68 @@ func (cl *client) JoinGame(gameKey string) error {
   return nil
 }
 
+/* Follow a game as a spectator: keep the store in sync, but do not
+   register bots nor save the game as the current game. */
+func (cl *client) WatchGame(gameKey string) error {
+  var err error
+  cl.notifier.Partial("Retrieving game state")
+  game, err := cl.remote.ShowGame(gameKey)
+  if err != nil { return err }
+  cl.setGame(game)
+  cl.gameChannel = "game:" + cl.game.Key
+  cl.watching = true
+  cl.resetPhase()
+  err = cl.subscribe(cl.gameChannel)
+  if err != nil { return err }
+  cl.notifier.Partial("Loading store index")
+  err = cl.store.Load()
+  if err != nil {
+    cl.notifier.Partial("Clearing corrupted store")
+    cl.store.Clear()
+  }
+  cl.notifier.Partial("Retrieving blocks")
+  err = cl.store.GetChain(cl.game.FirstBlock, cl.game.LastBlock)
+  if err != nil { return err }
+  return nil
+}
+
 func (cl *client) Game() *api.GameState {
-  return cl.game
+  return cl.Snapshot().Game
+}
+
+func (cl *client) Snapshot() Snapshot {
+  cl.stateMutex.Lock()
+  snap := Snapshot{Game: cl.game, Bots: cl.bots, BotRanks: cl.botRanks}
+  cl.stateMutex.Unlock()
+  snap.Deadline, _ = cl.deadline(snap.Game)
+  return snap
+}
+
+func (cl *client) setGame(game *api.GameState) {
+  cl.stateMutex.Lock()
+  cl.game = game
+  cl.stateMutex.Unlock()
+}
+
+func (cl *client) setBots(bots []BotConfig) {
+  cl.stateMutex.Lock()
+  cl.bots = bots
+  cl.stateMutex.Unlock()
+}
+
+func (cl *client) setBotRanks(ranks []uint32) {
+  cl.stateMutex.Lock()
+  cl.botRanks = ranks
+  cl.stateMutex.Unlock()
+}
+
+/* Context fields for messages about the current game. */
+func (cl *client) fields(extra notify.Fields) notify.Fields {
+  var res = notify.Fields{}
+  if cl.game != nil { res["game"] = cl.game.Key }
+  return notify.Merge(res, extra)
+}
+
+func (cl *client) BotRanks() []uint32 {
+  return cl.Snapshot().BotRanks
 }
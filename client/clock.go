@@ -0,0 +1,134 @@
+package client
+
+import (
+  "sort"
+  "sync"
+  "time"
+  "tezos-contests.izibi.com/tc-node/api"
+  "tezos-contests.izibi.com/tc-node/metrics"
+)
+
+/* Number of samples taken by an initial clock synchronisation, and number
+   of samples kept for the estimate. */
+const clockBurst = 4
+const clockSamples = 16
+
+type clockSample struct {
+  offset time.Duration /* server clock minus local clock */
+  rtt time.Duration
+}
+
+/* NTP-style estimate of the offset between the local and server clocks.
+   Each sample measures the round trip of a time request; the server
+   timestamp is assumed to be taken half-way through it.  The estimate is
+   the median offset of the samples with the shortest round trips, which
+   are the least affected by network jitter. */
+type Clock struct {
+  remote *api.Server
+  mutex sync.Mutex
+  samples []clockSample
+  offset time.Duration
+  rtt time.Duration
+  coarse bool /* the server sends whole seconds */
+}
+
+func NewClock(remote *api.Server) *Clock {
+  return &Clock{remote: remote}
+}
+
+/* Query the server time and add a sample to the estimate. */
+func (c *Clock) Sample() error {
+  t0 := time.Now()
+  serverTime, err := c.remote.GetTime()
+  if err != nil { return err }
+  rtt := time.Since(t0)
+  sample, coarse := newClockSample(t0, serverTime, rtt)
+  c.mutex.Lock()
+  defer c.mutex.Unlock()
+  c.coarse = coarse
+  c.samples = append(c.samples, sample)
+  if len(c.samples) > clockSamples {
+    c.samples = c.samples[len(c.samples) - clockSamples:]
+  }
+  c.offset, c.rtt = estimateOffset(c.samples)
+  metrics.ClockOffset.Set(c.offset.Seconds())
+  metrics.ClockRoundTrip.Set(c.rtt.Seconds())
+  return nil
+}
+
+/* The sample of a time request sent at t0 and answered with serverTime
+   after rtt; also tells whether the server time was a whole second. */
+func newClockSample(t0 time.Time, serverTime time.Time, rtt time.Duration) (clockSample, bool) {
+  coarse := serverTime.Nanosecond() == 0
+  if coarse {
+    /* A whole second was sent: take the middle of that second. */
+    serverTime = serverTime.Add(time.Second / 2)
+  }
+  sample := clockSample{
+    offset: serverTime.Sub(t0.Add(rtt / 2)),
+    rtt: rtt,
+  }
+  return sample, coarse
+}
+
+/* The median offset and round trip of the half of the samples with the
+   shortest round trips. */
+func estimateOffset(samples []clockSample) (time.Duration, time.Duration) {
+  if len(samples) == 0 { return 0, 0 }
+  best := append([]clockSample(nil), samples...)
+  sort.Slice(best, func (i, j int) bool { return best[i].rtt < best[j].rtt })
+  best = best[:(len(best) + 1) / 2]
+  rtt := best[len(best) / 2].rtt
+  sort.Slice(best, func (i, j int) bool { return best[i].offset < best[j].offset })
+  return best[len(best) / 2].offset, rtt
+}
+
+/* Current estimate of the clock offset and of the round-trip time. */
+func (c *Clock) Estimate() (time.Duration, time.Duration) {
+  c.mutex.Lock()
+  defer c.mutex.Unlock()
+  return c.offset, c.rtt
+}
+
+/* Smallest change of the offset that the samples can show. */
+func (c *Clock) Resolution() time.Duration {
+  c.mutex.Lock()
+  defer c.mutex.Unlock()
+  if c.coarse { return time.Second }
+  return 0
+}
+
+/* Corrected server time. */
+func (c *Clock) Now() time.Time {
+  offset, _ := c.Estimate()
+  return time.Now().Add(offset)
+}
+
+/* Convert a server timestamp to local time. */
+func (c *Clock) LocalTime(serverTime time.Time) time.Time {
+  offset, _ := c.Estimate()
+  return serverTime.Add(-offset)
+}
+
+/* Sample the server clock periodically in the background, warning when the
+   estimated offset moves by more than threshold. */
+func (cl *client) StartClockSync(interval time.Duration, threshold time.Duration) {
+  if interval <= 0 { return }
+  go func() {
+    reference, _ := cl.clock.Estimate()
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for range ticker.C {
+      err := cl.clock.Sample()
+      if err != nil { continue }
+      offset, _ := cl.clock.Estimate()
+      drift := offset - reference
+      if drift < 0 { drift = -drift }
+      if threshold > 0 && drift > threshold && drift > cl.clock.Resolution() {
+        cl.notifier.Warningf("Local clock drifted by %v (offset to server %v)",
+          drift, offset)
+        reference = offset
+      }
+    }
+  }()
+}
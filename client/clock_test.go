@@ -0,0 +1,68 @@
+
+package client
+
+import (
+  "testing"
+  "time"
+)
+
+func ms(n int) time.Duration {
+  return time.Duration(n) * time.Millisecond
+}
+
+func TestEstimateOffset(t *testing.T) {
+  var tests = []struct {
+    name string
+    samples []clockSample
+    offset time.Duration
+    rtt time.Duration
+  }{
+    {"no samples", nil, 0, 0},
+    {"one sample", []clockSample{{ms(5), ms(20)}}, ms(5), ms(20)},
+    /* The samples with long round trips are left out. */
+    {"outliers", []clockSample{
+      {ms(5), ms(10)}, {ms(-90), ms(200)}, {ms(7), ms(12)}, {ms(150), ms(300)},
+    }, ms(7), ms(12)},
+    /* A delay on one way only shifts the offset by half of it; the median
+       of the fast samples is not moved by the slow ones. */
+    {"asymmetric round trips", []clockSample{
+      {ms(0), ms(10)}, {ms(35), ms(80)}, {ms(1), ms(11)}, {ms(-40), ms(90)}, {ms(-1), ms(12)},
+    }, ms(0), ms(11)},
+    {"all slow", []clockSample{
+      {ms(100), ms(500)}, {ms(120), ms(400)}, {ms(-50), ms(600)},
+    }, ms(120), ms(500)},
+  }
+  for _, test := range tests {
+    offset, rtt := estimateOffset(test.samples)
+    if offset != test.offset || rtt != test.rtt {
+      t.Errorf("%s: got offset %v, rtt %v, want %v, %v", test.name,
+        offset, rtt, test.offset, test.rtt)
+    }
+  }
+}
+
+func TestNewClockSample(t *testing.T) {
+  t0 := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+  var tests = []struct {
+    name string
+    serverTime time.Time
+    rtt time.Duration
+    offset time.Duration
+    coarse bool
+  }{
+    /* Server time taken half-way through the request. */
+    {"precise", t0.Add(2 * time.Second + ms(50)), ms(100), 2 * time.Second, false},
+    {"precise, server behind", t0.Add(ms(-1950)), ms(100), -2 * time.Second, false},
+    /* A whole second stands for any time in that second: the middle is
+       taken, so the error is at most half a second. */
+    {"whole second", t0.Add(2 * time.Second), ms(100), ms(2450), true},
+    {"whole second, server behind", t0.Add(-3 * time.Second), ms(100), ms(-2550), true},
+  }
+  for _, test := range tests {
+    sample, coarse := newClockSample(t0, test.serverTime, test.rtt)
+    if sample.offset != test.offset || sample.rtt != test.rtt || coarse != test.coarse {
+      t.Errorf("%s: got offset %v, rtt %v, coarse %v, want %v, %v, %v", test.name,
+        sample.offset, sample.rtt, coarse, test.offset, test.rtt, test.coarse)
+    }
+  }
+}
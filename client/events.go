@@ -1,11 +1,9 @@
-
 package client
 
 import (
   "fmt"
-  "encoding/json"
+  "errors"
   "strings"
-  "tezos-contests.izibi.com/tc-node/sse"
 )
 
 type Event struct {
@@ -25,55 +23,120 @@ type NewBlockEvent struct {
   Hash string
 }
 
+type PingEvent struct {
+  Payload string
+}
+
 func (cl *client) Connect() (<-chan interface{}, error) {
+  stream, err := NewEventStream(cl.remote, cl.notifier)
+  if err != nil { return nil, err }
+  return cl.connect(stream), nil
+}
+
+/* Receive the client's events from a (possibly shared) event stream. */
+func (cl *client) connect(stream *EventStream) <-chan interface{} {
   if cl.eventChannel != nil {
     panic("Connect() must only be called once!")
   }
-  key, err := cl.remote.NewStream()
-  if err != nil { return nil, err }
-  evs, err := sse.Connect(fmt.Sprintf("%s/Events/%s", cl.remote.Base, key))
-  if err != nil { return nil, err }
-  cl.eventsKey = key
+  cl.stream = stream
+  cl.sink = stream.attach()
+  cl.done = make(chan struct{})
   ech := make(chan interface{})
+  /* Forward an event, unless the client was closed. */
+  send := func(v interface{}) bool {
+    select {
+    case ech<- v:
+      return true
+    case <-cl.done:
+      return false
+    }
+  }
   go func() {
-    defer evs.Close()
-    for {
-      msg := <-evs.C
-      if msg == "" { break }
-      var ev Event
-      err = json.Unmarshal([]byte(msg), &ev)
-      if err != nil { /* XXX report bad event */ continue }
+    for v := range cl.sink.ch {
+      ev, ok := v.(Event)
+      if !ok {
+        if !send(v) { return }
+        continue
+      }
       if ev.Channel == "system" {
-        ech <- SystemEvent{Payload: ev.Payload}
+        if !send(SystemEvent{Payload: ev.Payload}) { return }
         continue
       }
-      if ev.Channel == cl.gameChannel {
-        var parts = strings.Split(ev.Payload, " ")
-        if len(parts) == 0 { continue }
-        switch parts[0] {
-        case "end": // ["end", reason]
-          ech <- EndOfGameEvent{Reason: parts[1]}
-        case "block": // ["block", hash]
-          ech <- NewBlockEvent{Hash: parts[1]}
-        case "ping": // ["ping" payload]
-          /* Perform PONG request directly, because the worker might be busy
-             doing the PING. */
-          var ids = make([]uint32, len(cl.bots))
-          for i, bot := range cl.bots {
-            ids[i] = bot.Id
-          }
-          err = cl.remote.Pong(cl.game.Key, parts[1], ids)
-          if err != nil { ech<- err }
+      if ev.Channel != cl.gameChannel {
+        continue
+      }
+      gev, err := parseGameEvent(ev.Payload)
+      if err != nil {
+        if !send(err) { return }
+        continue
+      }
+      switch e := gev.(type) {
+      case EndOfGameEvent:
+        cl.setPhase(PhaseEnded)
+        if !send(e) { return }
+      case NewBlockEvent:
+        cl.setLatestBlock(e.Hash)
+        if !send(e) { return }
+      case PingEvent:
+        /* A watcher has no bots to answer for. */
+        if cl.watching { continue }
+        /* Perform PONG request directly, because the worker might be busy
+           doing the PING. */
+        snap := cl.Snapshot()
+        if snap.Game == nil { continue }
+        var ids = make([]uint32, len(snap.Bots))
+        for i, bot := range snap.Bots {
+          ids[i] = bot.Id
         }
+        err = cl.remote.Pong(snap.Game.Key, e.Payload, ids)
+        if err != nil && !send(err) { return }
       }
     }
   }()
   cl.eventChannel = ech
-  return ech, nil
+  return ech
+}
+
+/* Parse the payload of an event received on the game channel.
+   Payloads are space-separated words:
+     end [REASON]
+     block HASH
+     ping PAYLOAD
+*/
+func parseGameEvent(payload string) (interface{}, error) {
+  var parts = strings.Fields(payload)
+  if len(parts) == 0 {
+    return nil, errors.New("empty game event")
+  }
+  switch parts[0] {
+  case "end":
+    var ev EndOfGameEvent
+    if len(parts) > 1 { ev.Reason = parts[1] }
+    return ev, nil
+  case "block":
+    if len(parts) != 2 {
+      return nil, fmt.Errorf("malformed block event %q", payload)
+    }
+    return NewBlockEvent{Hash: parts[1]}, nil
+  case "ping":
+    if len(parts) != 2 {
+      return nil, fmt.Errorf("malformed ping event %q", payload)
+    }
+    return PingEvent{Payload: parts[1]}, nil
+  }
+  return nil, fmt.Errorf("unknown game event %q", payload)
+}
+
+/* Stop receiving events: detach the client from the event stream, which
+   may be shared with other clients. */
+func (cl *client) Close() {
+  cl.closeOnce.Do(func() {
+    if cl.stream == nil { return }
+    cl.stream.detach(cl.sink)
+    close(cl.done)
+  })
 }
 
 func (cl *client) subscribe(name string) error {
-  err := cl.remote.Subscribe(cl.eventsKey, []string{name})
-  if err != nil { return err }
-  return nil
+  return cl.stream.subscribe(cl.sink, name)
 }
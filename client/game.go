@@ -7,17 +7,20 @@ import (
   "fmt"
   "io/ioutil"
   "os"
+  "time"
   "tezos-contests.izibi.com/tc-node/api"
+  "tezos-contests.izibi.com/tc-node/notify"
 )
 
 func (cl *client) loadGame() error {
   var err error
   var b []byte
-  filepath := "game.json"
+  filepath := cl.workspace.StatePath()
   _, err = os.Stat(filepath)
   if os.IsNotExist(err) {
-    cl.game = nil
+    cl.setGame(nil)
     cl.gameChannel = ""
+    cl.setPhase(PhaseNone)
     return nil
   }
   b, err = ioutil.ReadFile(filepath)
@@ -25,8 +28,9 @@ func (cl *client) loadGame() error {
   game := new(api.GameState)
   err = json.NewDecoder(bytes.NewBuffer(b)).Decode(game)
   if err != nil { return err }
-  cl.game = game
+  cl.setGame(game)
   cl.gameChannel = "game:" + game.Key
+  cl.resetPhase()
   err = cl.subscribe(cl.gameChannel)
   if err != nil { return err }
   return nil
@@ -38,43 +42,103 @@ func (cl *client) syncGame() (uint64, error) {
   cl.notifier.Partial("Retrieving game state")
   game, err = cl.remote.ShowGame(cl.game.Key)
   if err != nil { return 0, err }
-  cl.game = game
-  if !cl.botsRegistered {
-    err = cl.registerBots()
+  cl.setGame(game)
+  cl.trackBlock()
+  cl.updatePhase()
+  if !cl.watching {
+    if !cl.botsRegistered {
+      err = cl.registerBots()
+      if err != nil { return 0, err }
+    }
+    cl.notifier.Partial("Saving game state")
+    err = cl.saveGame()
     if err != nil { return 0, err }
   }
-  cl.notifier.Partial("Saving game state")
-  err = cl.saveGame()
-  if err != nil { return 0, err }
   cl.notifier.Partial("Retrieving blocks")
   err = cl.store.GetChain(cl.game.FirstBlock, cl.game.LastBlock)
   if err != nil { return 0, err }
   var currentRound uint64
   currentRound, err = cl.lastRoundNumber()
   if err != nil { return 0, err }
-  cl.notifier.Final(fmt.Sprintf("Up-to-date at round %d", currentRound))
+  cl.notifier.Log(notify.Info, fmt.Sprintf("Up-to-date at round %d", currentRound),
+    cl.fields(notify.Fields{"round": currentRound, "block": cl.game.LastBlock}))
+  cl.emitRound()
   return currentRound, nil
 }
 
+/* Number of extra attempts, and delay between them, made by syncLatestBlock
+   while the game state lags behind the event stream. */
+const syncAttempts = 5
+const syncRetryDelay = 500 * time.Millisecond
+
+/* Sync the game, retrying while the game state lags behind the last block
+   announced on the event stream.  The boolean result is false if the game
+   state never caught up, in which case bots must not be run. */
+func (cl *client) syncLatestBlock() (uint64, bool, error) {
+  var attempt int
+  for {
+    currentRound, err := cl.syncGame()
+    if err != nil { return 0, false, err }
+    if !cl.blockSuperseded() {
+      return currentRound, true, nil
+    }
+    if attempt == syncAttempts {
+      return currentRound, false, nil
+    }
+    attempt += 1
+    cl.notifier.Partialf("Waiting for block %s", cl.getLatestBlock())
+    time.Sleep(syncRetryDelay)
+  }
+}
+
+func (cl *client) setLatestBlock(hash string) {
+  cl.latestBlockMutex.Lock()
+  cl.latestBlock = hash
+  cl.latestBlockMutex.Unlock()
+}
+
+func (cl *client) getLatestBlock() string {
+  cl.latestBlockMutex.Lock()
+  defer cl.latestBlockMutex.Unlock()
+  return cl.latestBlock
+}
+
+/* A block announced on the event stream that is not part of the synced
+   chain is more recent than the current block, which is then superseded. */
+func (cl *client) blockSuperseded() bool {
+  if cl.game == nil { return false }
+  latest := cl.getLatestBlock()
+  if latest == "" || latest == cl.game.LastBlock {
+    return false
+  }
+  _, known := cl.store.Index.GetRoundByHash(latest)
+  return !known
+}
+
 func (cl *client) saveGame() (err error) {
   buf := new(bytes.Buffer)
   json.NewEncoder(buf).Encode(cl.game)
-  err = ioutil.WriteFile("game.json", buf.Bytes(), 0644)
+  err = ioutil.WriteFile(cl.workspace.StatePath(), buf.Bytes(), 0644)
   return
 }
 
 func (c *client) registerBots() error {
   var err error
   c.notifier.Partial("Registering bots")
+  c.setPhase(PhaseRegistering)
   var ids = make([]uint32, len(c.bots))
   for i, c := range c.bots {
     ids[i] = c.Id
   }
   var ranks []uint32
   ranks, err = c.remote.Register(c.game.Key, ids)
-  if err != nil { return err }
+  if err != nil {
+    if c.Phase() == PhaseRegistering { c.setPhase(PhaseCreated) }
+    return err
+  }
   c.botsRegistered = true
-  c.botRanks = ranks
+  c.setBotRanks(ranks)
+  c.updatePhase()
   if len(ranks) < len(c.bots) {
     c.notifier.Warning(fmt.Sprintf("Game is full, %d bots will play", len(ranks)))
   }
@@ -0,0 +1,100 @@
+package client
+
+import (
+  "fmt"
+  "os"
+  "path/filepath"
+  "tezos-contests.izibi.com/backend/signing"
+  "tezos-contests.izibi.com/tc-node/api"
+  "tezos-contests.izibi.com/tc-node/block_store"
+  "tezos-contests.izibi.com/tc-node/history"
+)
+
+/* One of the games played by a Manager. */
+type GameConfig struct {
+  Name string `yaml:"name"`
+  /* Game to join if the game has no state file yet; a new game is created
+     with NewGameParams if empty. */
+  GameKey string `yaml:"game_key"`
+  NewGameParams map[string]interface{} `yaml:"new_game_params"`
+  /* Directory of the game's state file and commands log, and working
+     directory of its bots; relative to the manager's workspace. */
+  Workspace string `yaml:"workspace"`
+  Bots []BotConfig `yaml:"bots"`
+}
+
+/* Plays several games at once.  The games share the API server and the
+   event stream; each game has its own bots, its own directory in the store,
+   and its own state file and commands log: game.json and commands.log in
+   the game's workspace if it has one, game-NAME.json and commands-NAME.log
+   in the manager's workspace otherwise. */
+type Manager struct {
+  notifier Notifier
+  task string
+  remote *api.Server
+  storeBaseUrl string
+  storeDir string
+  history *history.History
+  workspace Workspace
+  teamKeyPair *signing.KeyPair
+  stream *EventStream
+  names map[string]bool
+}
+
+func NewManager(notifier Notifier, task string, remote *api.Server, storeBaseUrl string, storeDir string, hist *history.History, workspace Workspace, teamKeyPair *signing.KeyPair) *Manager {
+  return &Manager{
+    notifier: notifier,
+    task: task,
+    remote: remote,
+    storeBaseUrl: storeBaseUrl,
+    storeDir: storeDir,
+    history: hist,
+    workspace: workspace,
+    teamKeyPair: teamKeyPair,
+    names: make(map[string]bool),
+  }
+}
+
+/* Start playing a game: reload it from its state file, or join or create
+   it.  Returns the game's client and its events. */
+func (m *Manager) Add(cfg GameConfig) (Client, <-chan interface{}, error) {
+  var err error
+  if cfg.Name == "" || filepath.Base(cfg.Name) != cfg.Name {
+    return nil, nil, fmt.Errorf("bad game name %q", cfg.Name)
+  }
+  if m.names[cfg.Name] {
+    return nil, nil, fmt.Errorf("duplicate game name %q", cfg.Name)
+  }
+  if m.stream == nil {
+    m.stream, err = NewEventStream(m.remote, m.notifier)
+    if err != nil { return nil, nil, err }
+  }
+  store := block_store.New(m.storeBaseUrl, filepath.Join(m.storeDir, cfg.Name))
+  workspace := m.workspace
+  if cfg.Workspace != "" {
+    workspace.Dir = m.workspace.Path(cfg.Workspace)
+    err = os.MkdirAll(workspace.Dir, 0755)
+    if err != nil { return nil, nil, err }
+  } else {
+    workspace.StateFile = fmt.Sprintf("game-%s.json", cfg.Name)
+    workspace.CommandsLog = fmt.Sprintf("commands-%s.log", cfg.Name)
+  }
+  cl := New(m.notifier, m.task, m.remote, store, m.history, workspace, m.teamKeyPair, cfg.Bots).(*client)
+  ech := cl.connect(m.stream)
+  _, err = os.Stat(workspace.StatePath())
+  switch {
+  case err == nil:
+    err = cl.LoadGame()
+  case cfg.GameKey != "":
+    err = cl.JoinGame(cfg.GameKey)
+  default:
+    err = cl.NewGame(cfg.NewGameParams)
+  }
+  if err != nil {
+    /* Detach from the shared stream, which outlives this client. */
+    cl.Close()
+    return nil, nil, err
+  }
+  m.names[cfg.Name] = true
+  return cl, ech, nil
+}
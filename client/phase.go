@@ -0,0 +1,84 @@
+package client
+
+import (
+  "fmt"
+  "tezos-contests.izibi.com/tc-node/api"
+)
+
+/* Lifecycle of the current game, as seen by this node.
+   The phases are ordered: a game only moves forward, except between running
+   and locked (the server may lock a game temporarily), and back to created
+   when a new game is created or joined. */
+type GamePhase uint
+
+const (
+  PhaseNone GamePhase = iota /* no current game */
+  PhaseCreated               /* game loaded, bots not registered */
+  PhaseRegistering           /* bot registration in progress */
+  PhaseWaiting               /* bots registered, game not started */
+  PhaseRunning               /* rounds are being played */
+  PhaseLocked                /* server no longer accepts commands */
+  PhaseEnded                 /* end-of-game event received */
+)
+
+var phaseNames = []string{
+  "none", "created", "registering", "waiting", "running", "locked", "ended",
+}
+
+func (p GamePhase) String() string {
+  if int(p) < len(phaseNames) {
+    return phaseNames[p]
+  }
+  return fmt.Sprintf("phase(%d)", uint(p))
+}
+
+func (cl *client) Phase() GamePhase {
+  cl.phaseMutex.Lock()
+  defer cl.phaseMutex.Unlock()
+  return cl.phase
+}
+
+/* Move to the given phase, ignoring transitions that would go backwards. */
+func (cl *client) setPhase(phase GamePhase) {
+  cl.phaseMutex.Lock()
+  defer cl.phaseMutex.Unlock()
+  if !canEnterPhase(cl.phase, phase) { return }
+  cl.phase = phase
+}
+
+func canEnterPhase(from GamePhase, to GamePhase) bool {
+  switch {
+  case to == from:
+    return false
+  case to == PhaseNone, to == PhaseCreated:
+    return true
+  case from == PhaseEnded:
+    return false
+  case from == PhaseLocked && to == PhaseRunning:
+    return true
+  }
+  return to > from
+}
+
+/* Derive the phase from the last game state received from the server. */
+func (cl *client) updatePhase() {
+  var game *api.GameState = cl.game
+  switch {
+  case game == nil:
+    cl.setPhase(PhaseNone)
+  case game.IsLocked:
+    cl.setPhase(PhaseLocked)
+  case game.StartedAt != nil:
+    cl.setPhase(PhaseRunning)
+  case cl.botsRegistered:
+    cl.setPhase(PhaseWaiting)
+  }
+}
+
+/* Reset the phase when switching to a different game. */
+func (cl *client) resetPhase() {
+  cl.phaseMutex.Lock()
+  cl.phase = PhaseCreated
+  cl.phaseMutex.Unlock()
+  cl.updatePhase()
+}
@@ -0,0 +1,220 @@
+package client
+
+import (
+  "encoding/json"
+  "io/ioutil"
+  "path/filepath"
+  "time"
+  "github.com/go-errors/errors"
+  "tezos-contests.izibi.com/tc-node/api"
+)
+
+/* Sent on the event channel whenever the status of the current round
+   changes: after a sync, and when a player's commands are accepted. */
+type RoundEvent struct {
+  Seq uint64
+  Round uint64
+  Block string
+  Deadline time.Time /* local time, zero if the server did not set one */
+  NbPlayers int
+  NbSubmitted int
+}
+
+/* Forget about submissions when the current block changes. */
+func (cl *client) trackBlock() {
+  if cl.game == nil { return }
+  if cl.trackedBlock == cl.game.LastBlock { return }
+  cl.trackedBlock = cl.game.LastBlock
+  cl.roundMutex.Lock()
+  cl.submitted = make(map[uint32]bool)
+  cl.roundMutex.Unlock()
+}
+
+func (cl *client) setSubmitted(rank uint32) {
+  cl.roundMutex.Lock()
+  if cl.submitted == nil {
+    cl.submitted = make(map[uint32]bool)
+  }
+  cl.submitted[rank] = true
+  cl.roundMutex.Unlock()
+}
+
+/* Record the players seen in the results of a ping. */
+func (cl *client) setPlayers(ranks []uint32) {
+  cl.roundMutex.Lock()
+  cl.players = make(map[uint32]bool)
+  for _, rank := range ranks {
+    cl.players[rank] = true
+  }
+  cl.roundMutex.Unlock()
+}
+
+/* Registered players are known from ping results and our own bots; if no
+   ping was performed, assume every slot in the game is taken. */
+func (cl *client) nbPlayers() int {
+  cl.roundMutex.Lock()
+  var players = make(map[uint32]bool)
+  for rank := range cl.players {
+    players[rank] = true
+  }
+  var pinged = len(cl.players) != 0
+  cl.roundMutex.Unlock()
+  for i, rank := range cl.botRanks {
+    if i < len(cl.bots) { players[rank] = true }
+  }
+  if !pinged {
+    params, err := cl.gameParams()
+    if err == nil && int(params.NbPlayers) > len(players) {
+      return int(params.NbPlayers)
+    }
+  }
+  return len(players)
+}
+
+/* Local time at which the current round ends, corrected by the measured
+   difference between the local and server clocks. */
+func (cl *client) RoundDeadline() (time.Time, bool) {
+  return cl.deadline(cl.Game())
+}
+
+func (cl *client) deadline(game *api.GameState) (time.Time, bool) {
+  if game == nil || game.RoundEndsAt == nil {
+    return time.Time{}, false
+  }
+  serverTime, err := time.Parse(time.RFC3339, *game.RoundEndsAt)
+  if err != nil {
+    return time.Time{}, false
+  }
+  return cl.clock.LocalTime(serverTime), true
+}
+
+/* Send the round status to the interactive loop.  This is called from the
+   worker, so the event is sent asynchronously to avoid a deadlock with a
+   loop that is blocked sending a command. */
+func (cl *client) emitRound() {
+  if cl.eventChannel == nil || cl.game == nil { return }
+  round, err := cl.lastRoundNumber()
+  if err != nil { return }
+  deadline, _ := cl.RoundDeadline()
+  cl.roundMutex.Lock()
+  cl.roundSeq += 1
+  ev := RoundEvent{
+    Seq: cl.roundSeq,
+    Round: round,
+    Block: cl.game.LastBlock,
+    Deadline: deadline,
+    NbSubmitted: len(cl.submitted),
+  }
+  cl.roundMutex.Unlock()
+  ev.NbPlayers = cl.nbPlayers()
+  ech, done := cl.eventChannel, cl.done
+  go func() {
+    select {
+    case ech <- ev:
+    case <-done:
+    }
+  }()
+}
+
+/* Read the game parameters from the setup block (the game's first block). */
+func (cl *client) gameParams() (*api.GameParams, error) {
+  if cl.params != nil && cl.paramsBlock == cl.game.FirstBlock {
+    return cl.params, nil
+  }
+  blockPath := filepath.Join(cl.store.BlockDir(cl.game.FirstBlock), "block.json")
+  bs, err := ioutil.ReadFile(blockPath)
+  if err != nil { return nil, errors.Errorf("failed to read '%s'", blockPath) }
+  var setup api.SetupBlock
+  err = json.Unmarshal(bs, &setup)
+  if err != nil { return nil, errors.Errorf("bad setup block: %s", err) }
+  cl.params = &setup.GameParams
+  cl.paramsBlock = cl.game.FirstBlock
+  return cl.params, nil
+}
+
+/* Settings of the automatic end of round. */
+type AutoCloseConfig struct {
+  Enabled bool `yaml:"enabled"`
+  AllSubmitted bool `yaml:"all_submitted"`
+  Deadline bool `yaml:"deadline"`
+  Margin time.Duration `yaml:"margin"`
+}
+
+/* Decide when to close a round: as soon as all registered players have
+   submitted their commands, or when the round deadline is reached.
+   Only the submissions of this node's bots are known, so the first
+   condition only holds when it plays all the players.
+   Feed it RoundEvents with Update, and call Expired when the channel
+   returned by C fires. */
+type RoundScheduler struct {
+  config AutoCloseConfig
+  seq uint64
+  block string
+  closed bool
+  timer *time.Timer
+}
+
+func NewRoundScheduler(config AutoCloseConfig) *RoundScheduler {
+  return &RoundScheduler{config: config}
+}
+
+/* Why a round should be closed. */
+type CloseReason int
+
+const (
+  CloseNone CloseReason = iota
+  CloseAllSubmitted
+  CloseDeadline
+)
+
+/* Update the scheduler with the round status.  Returns the reason to close
+   the round now, if any. */
+func (s *RoundScheduler) Update(ev RoundEvent) CloseReason {
+  if ev.Seq <= s.seq { return CloseNone } /* out of order */
+  s.seq = ev.Seq
+  if ev.Block != s.block {
+    s.block = ev.Block
+    s.closed = false
+  }
+  s.stopTimer()
+  if s.closed { return CloseNone }
+  if s.config.AllSubmitted && ev.NbPlayers > 0 && ev.NbSubmitted >= ev.NbPlayers {
+    s.closed = true
+    return CloseAllSubmitted
+  }
+  if s.config.Deadline && !ev.Deadline.IsZero() {
+    delay := time.Until(ev.Deadline.Add(s.config.Margin))
+    if delay <= 0 {
+      s.closed = true
+      return CloseDeadline
+    }
+    s.timer = time.NewTimer(delay)
+  }
+  return CloseNone
+}
+
+/* The deadline timer channel, nil if no deadline is pending. */
+func (s *RoundScheduler) C() <-chan time.Time {
+  if s.timer == nil { return nil }
+  return s.timer.C
+}
+
+/* Called when the deadline timer fires.  Returns true if the round should
+   be closed now. */
+func (s *RoundScheduler) Expired() bool {
+  s.timer = nil
+  if s.closed { return false }
+  s.closed = true
+  return true
+}
+
+func (s *RoundScheduler) Stop() {
+  s.stopTimer()
+}
+
+func (s *RoundScheduler) stopTimer() {
+  if s.timer != nil {
+    s.timer.Stop()
+    s.timer = nil
+  }
+}
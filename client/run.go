@@ -3,39 +3,203 @@ package client
 
 import (
   "bytes"
+  "encoding/json"
   "fmt"
+  "io"
   "os"
   "os/exec"
   "runtime"
+  "strconv"
   "strings"
+  "sync"
+  "tezos-contests.izibi.com/tc-node/api"
 )
 
+/* Handling of the bots' standard error. */
+type StderrConfig struct {
+  Quiet bool `yaml:"quiet"`      /* do not copy it to the terminal */
+  MaxSize int `yaml:"max_size"` /* bytes kept per run */
+  Out io.Writer `yaml:"-"` /* where it is copied, os.Stderr if nil */
+}
+
+const defaultStderrMaxSize = 64 * 1024
+
+/* What a bot is told about the round.  In the json input mode, this is
+   what the bot reads on its standard input. */
 type CommandEnv struct {
-  RoundNumber uint64
-  PlayerNumber uint32
-  NbCycles uint
-  BotId uint32
+  RoundNumber uint64 `json:"round_number"`
+  PlayerNumber uint32 `json:"player_number"`
+  NbCycles uint `json:"nb_cycles"`
+  BotId uint32 `json:"bot_id"`
+  GameKey string `json:"game_key"`
+  TeamKey string `json:"team_key"`
+  LastBlock string `json:"last_block"`
+  BlockDir string `json:"block_dir"`
+  StatePath string `json:"state_path"`
+  RoundDeadline string `json:"round_deadline"` /* server time, RFC3339 */
+  GameParams *api.GameParams `json:"game_params"`
+  /* block.json of the last block, when it is a command block: it holds
+     the commands of all players in the previous round. */
+  PreviousBlockPath string `json:"previous_block_path"`
+  /* This player's commands in that block, one per cycle ("" for none). */
+  PreviousCommands []string `json:"previous_commands"`
 }
 
-func runCommand(shellCmd string, env CommandEnv) (string, error) {
+/* Bot input modes. */
+const (
+  InputText = "text" /* "ROUND PLAYER" on a single line */
+  InputJson = "json" /* the CommandEnv, as a JSON object */
+)
+
+/* Inputs and outputs of a bot run. */
+type CommandRun struct {
+  Env []string /* variables set by tc-node */
+  Input string
+  Stdout string
+  Stderr string
+}
+
+/* Run a bot command in dir (the current directory if empty). */
+func runCommand(shellCmd string, dir string, inputMode string, env CommandEnv, stderrConfig StderrConfig) (*CommandRun, error) {
   var cmd *exec.Cmd
   if runtime.GOOS == "windows" {
     cmd = exec.Command("cmd.exe", "/C", shellCmd)
   } else {
     cmd = exec.Command("sh", "-c", shellCmd)
   }
-  cmd.Env = append(os.Environ(),
-    fmt.Sprintf("ROUND_NUMBER=%d", env.RoundNumber),
-    fmt.Sprintf("PLAYER_NUMBER=%d", env.PlayerNumber),
-    fmt.Sprintf("NB_CYCLES=%d", env.NbCycles),
-    fmt.Sprintf("BOT_ID=%d", env.BotId),
-  )
-  input := fmt.Sprintf("%d %d", env.RoundNumber, env.PlayerNumber)
-  cmd.Stdin = strings.NewReader(input)
-  cmd.Stderr = os.Stderr
+  run := &CommandRun{
+    Env: []string{
+      fmt.Sprintf("ROUND_NUMBER=%d", env.RoundNumber),
+      fmt.Sprintf("PLAYER_NUMBER=%d", env.PlayerNumber),
+      fmt.Sprintf("NB_CYCLES=%d", env.NbCycles),
+      fmt.Sprintf("BOT_ID=%d", env.BotId),
+      fmt.Sprintf("GAME_KEY=%s", env.GameKey),
+      fmt.Sprintf("TEAM_KEY=%s", env.TeamKey),
+      fmt.Sprintf("LAST_BLOCK=%s", env.LastBlock),
+      fmt.Sprintf("BLOCK_DIR=%s", env.BlockDir),
+      fmt.Sprintf("STATE_PATH=%s", env.StatePath),
+      fmt.Sprintf("ROUND_DEADLINE=%s", env.RoundDeadline),
+      fmt.Sprintf("PREVIOUS_BLOCK_PATH=%s", env.PreviousBlockPath),
+    },
+  }
+  if env.GameParams != nil {
+    run.Env = append(run.Env,
+      fmt.Sprintf("NB_PLAYERS=%d", env.GameParams.NbPlayers),
+      fmt.Sprintf("MAP_SIDE=%d", env.GameParams.MapSide),
+      fmt.Sprintf("NB_ROUNDS=%d", env.GameParams.NbRounds),
+      fmt.Sprintf("ROUND_DURATION=%d", env.GameParams.RoundDuration),
+      fmt.Sprintf("CYCLES_PER_ROUND=%d", env.GameParams.CyclesPerRound),
+    )
+  }
+  if inputMode == InputJson {
+    bs, err := json.Marshal(&env)
+    if err != nil { return run, err }
+    run.Input = string(bs) + "\n"
+  } else {
+    run.Input = fmt.Sprintf("%d %d", env.RoundNumber, env.PlayerNumber)
+  }
+  cmd.Dir = dir
+  cmd.Env = append(os.Environ(), run.Env...)
+  cmd.Stdin = strings.NewReader(run.Input)
+  stderr := &limitedBuffer{max: stderrConfig.MaxSize}
+  if stderr.max <= 0 { stderr.max = defaultStderrMaxSize }
+  var echo *prefixWriter
+  if stderrConfig.Quiet {
+    cmd.Stderr = stderr
+  } else {
+    var out io.Writer = os.Stderr
+    if stderrConfig.Out != nil { out = stderrConfig.Out }
+    echo = &prefixWriter{out: out, prefix: fmt.Sprintf("[bot %d] ", env.BotId)}
+    cmd.Stderr = io.MultiWriter(stderr, echo)
+  }
   var out bytes.Buffer
   cmd.Stdout = &out
   err := cmd.Run()
-  if err != nil { return "", err }
-  return out.String(), nil
+  if echo != nil { echo.Flush() }
+  run.Stdout = out.String()
+  run.Stderr = stderr.String()
+  return run, err
+}
+
+/* Run a bot outside of a game, as the worker would. */
+func RunBot(bot *BotConfig, dir string, env CommandEnv, stderrConfig StderrConfig) (*CommandRun, error) {
+  return runCommand(bot.Command, dir, bot.Input, env, stderrConfig)
+}
+
+/* The commands of a player in a command block, one per cycle, or nil if
+   there is no block. */
+func PlayerCommands(block *api.CommandBlock, rank uint32) []string {
+  if block == nil { return nil }
+  var commands = make([]string, len(block.Commands))
+  for cycle, cycleCommands := range block.Commands {
+    for _, cmd := range cycleCommands {
+      if cmd.PlayerRank == rank { commands[cycle] = cmd.Command }
+    }
+  }
+  return commands
+}
+
+/* Keeps the first max bytes written, and counts the rest. */
+type limitedBuffer struct {
+  buf bytes.Buffer
+  max int
+  dropped int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+  n := len(p)
+  room := b.max - b.buf.Len()
+  if room < n {
+    if room < 0 { room = 0 }
+    b.dropped += n - room
+    p = p[:room]
+  }
+  b.buf.Write(p)
+  return n, nil
+}
+
+func (b *limitedBuffer) String() string {
+  if b.dropped == 0 { return b.buf.String() }
+  return fmt.Sprintf("%s\n[... %d bytes truncated]\n", b.buf.String(), b.dropped)
+}
+
+/* Copies complete lines to out, each preceded by prefix. */
+type prefixWriter struct {
+  out io.Writer
+  prefix string
+  line []byte
+  mutex sync.Mutex
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+  w.mutex.Lock()
+  defer w.mutex.Unlock()
+  for _, c := range p {
+    w.line = append(w.line, c)
+    if c == '\n' {
+      w.out.Write(append([]byte(w.prefix), w.line...))
+      w.line = w.line[:0]
+    }
+  }
+  return len(p), nil
+}
+
+/* Write out the last line if it was not terminated. */
+func (w *prefixWriter) Flush() {
+  w.mutex.Lock()
+  defer w.mutex.Unlock()
+  if len(w.line) == 0 { return }
+  w.out.Write(append(append([]byte(w.prefix), w.line...), '\n'))
+  w.line = w.line[:0]
+}
+
+/* Exit status label for a bot run: the exit code, or "error" if the bot
+   could not be started or was killed. */
+func exitStatus(err error) string {
+  if err == nil { return "0" }
+  if exitErr, ok := err.(*exec.ExitError); ok {
+    code := exitErr.ExitCode()
+    if code >= 0 { return strconv.Itoa(code) }
+  }
+  return "error"
 }
@@ -0,0 +1,194 @@
+package client
+
+import (
+  "encoding/json"
+  "fmt"
+  "strings"
+  "sync"
+  "tezos-contests.izibi.com/tc-node/api"
+  "tezos-contests.izibi.com/tc-node/sse"
+)
+
+/* An event stream shared by several clients.  Each client gets the system
+   events, and the events of the channels it subscribed to. */
+type EventStream struct {
+  remote *api.Server
+  notifier Notifier
+  key string
+  mutex sync.Mutex
+  sinks []*eventSink
+  subscribed map[string]bool
+  closed bool
+}
+
+type eventSink struct {
+  ch chan interface{} /* Event, or error */
+  channels map[string]bool
+  queue []interface{} /* undelivered events, guarded by the stream's mutex */
+  wake chan struct{} /* the queue grew, or the stream ended */
+  stop chan struct{} /* closed when the sink is detached */
+  ended bool /* the stream ended, close ch once the queue is drained */
+}
+
+/* Length of a client's queue of undelivered events above which a warning
+   is shown.  Block notifications are coalesced, so the queue only grows
+   past this if other events pile up. */
+const eventSinkBacklog = 64
+
+func NewEventStream(remote *api.Server, notifier Notifier) (*EventStream, error) {
+  key, err := remote.NewStream()
+  if err != nil { return nil, err }
+  evs, err := sse.Connect(fmt.Sprintf("%s/Events/%s", remote.Base, key), notifier)
+  if err != nil { return nil, err }
+  es := &EventStream{
+    remote: remote,
+    notifier: notifier,
+    key: key,
+    subscribed: make(map[string]bool),
+  }
+  go func() {
+    defer evs.Close()
+    for {
+      msg := <-evs.C
+      if msg == "" { break }
+      var ev Event
+      err := json.Unmarshal([]byte(msg), &ev)
+      if err != nil {
+        es.dispatch("system", fmt.Errorf("malformed event %q: %v", msg, err))
+        continue
+      }
+      es.dispatch(ev.Channel, ev)
+    }
+    es.mutex.Lock()
+    es.closed = true
+    for _, sink := range es.sinks {
+      sink.ended = true
+      sink.signal()
+    }
+    es.sinks = nil
+    es.mutex.Unlock()
+  }()
+  return es, nil
+}
+
+/* Queue an event for each interested client without blocking, so that a
+   client that does not keep up cannot stall the stream.  Only block
+   notifications are coalesced (the client syncs to the latest block
+   anyway); end-of-game, system events and errors are always delivered. */
+func (es *EventStream) dispatch(channel string, v interface{}) {
+  var stalled int
+  es.mutex.Lock()
+  for _, sink := range es.sinks {
+    if channel != "system" && !sink.channels[channel] { continue }
+    sink.push(v)
+    if len(sink.queue) == eventSinkBacklog { stalled++ }
+  }
+  es.mutex.Unlock()
+  if stalled != 0 {
+    es.notifier.Warning(fmt.Sprintf("%d client(s) falling behind on the event stream", stalled))
+  }
+}
+
+/* Append an event to the queue, replacing a pending notification of an
+   older block on the same channel.  Called with the stream's mutex held. */
+func (sink *eventSink) push(v interface{}) {
+  if isBlockEvent(v) {
+    channel := v.(Event).Channel
+    for i, w := range sink.queue {
+      if isBlockEvent(w) && w.(Event).Channel == channel {
+        sink.queue = append(sink.queue[:i], sink.queue[i+1:]...)
+        break
+      }
+    }
+  }
+  sink.queue = append(sink.queue, v)
+  sink.signal()
+}
+
+func (sink *eventSink) signal() {
+  select {
+  case sink.wake<- struct{}{}:
+  default:
+  }
+}
+
+func isBlockEvent(v interface{}) bool {
+  ev, ok := v.(Event)
+  return ok && ev.Channel != "system" && strings.HasPrefix(ev.Payload, "block ")
+}
+
+/* Move the sink's queued events to its channel, in order. */
+func (es *EventStream) pump(sink *eventSink) {
+  defer close(sink.ch)
+  for {
+    es.mutex.Lock()
+    if len(sink.queue) == 0 {
+      ended := sink.ended
+      es.mutex.Unlock()
+      if ended { return }
+      select {
+      case <-sink.wake:
+      case <-sink.stop:
+        return
+      }
+      continue
+    }
+    v := sink.queue[0]
+    sink.queue[0] = nil
+    sink.queue = sink.queue[1:]
+    es.mutex.Unlock()
+    select {
+    case sink.ch<- v:
+    case <-sink.stop:
+      return
+    }
+  }
+}
+
+func (es *EventStream) attach() *eventSink {
+  sink := &eventSink{
+    ch: make(chan interface{}),
+    channels: make(map[string]bool),
+    wake: make(chan struct{}, 1),
+    stop: make(chan struct{}),
+  }
+  es.mutex.Lock()
+  if es.closed {
+    sink.ended = true
+  } else {
+    es.sinks = append(es.sinks, sink)
+  }
+  es.mutex.Unlock()
+  go es.pump(sink)
+  return sink
+}
+
+/* Stop delivering events to sink, and close its channel.  Must be called
+   at most once per sink. */
+func (es *EventStream) detach(sink *eventSink) {
+  es.mutex.Lock()
+  for i, s := range es.sinks {
+    if s == sink {
+      es.sinks = append(es.sinks[:i:i], es.sinks[i+1:]...)
+      break
+    }
+  }
+  es.mutex.Unlock()
+  close(sink.stop)
+}
+
+/* Deliver a channel's events to sink, subscribing the stream to the
+   channel if no other client did. */
+func (es *EventStream) subscribe(sink *eventSink, channel string) error {
+  es.mutex.Lock()
+  sink.channels[channel] = true
+  done := es.subscribed[channel]
+  es.mutex.Unlock()
+  if done { return nil }
+  err := es.remote.Subscribe(es.key, []string{channel})
+  if err != nil { return err }
+  es.mutex.Lock()
+  es.subscribed[channel] = true
+  es.mutex.Unlock()
+  return nil
+}
@@ -7,7 +7,15 @@ import (
   "fmt"
   "io"
   "os"
+  "path/filepath"
+  "sort"
+  "strconv"
   "strings"
+  "time"
+  "tezos-contests.izibi.com/tc-node/api"
+  "tezos-contests.izibi.com/tc-node/history"
+  "tezos-contests.izibi.com/tc-node/metrics"
+  "tezos-contests.izibi.com/tc-node/notify"
 )
 
 func (cl *client) Worker() (chan<- Command, chan<- Command) {
@@ -21,9 +29,12 @@ func (cl *client) Worker() (chan<- Command, chan<- Command) {
       var cmd Command
       select {
       case cmd = <-wch:
-        fmt.Printf("Processing command")
+        cl.notifier.Log(notify.Debug, "Processing command", nil)
       case cmd = <-ich.Out():
-        fmt.Printf("Processing idle command")
+        cl.notifier.Log(notify.Debug, "Processing idle command", nil)
+      case <-cl.syncRequest:
+        cl.notifier.Log(notify.Debug, "Processing sync request", nil)
+        cmd = SyncThenSendCommands()
       }
       err := cmd.run(cl)
       if err != nil {
@@ -36,10 +47,34 @@ func (cl *client) Worker() (chan<- Command, chan<- Command) {
   return wch, ich.In()
 }
 
+/* Have the worker sync to the latest block and send commands once it is
+   done with its current and queued commands.  Requests made while one is
+   pending are merged into it, as it syncs to the latest block when it
+   runs. */
+func (cl *client) RequestSync() {
+  select {
+  case cl.syncRequest<- struct{}{}:
+  default:
+  }
+}
+
 type Command struct {
   run func (cl *client) error
 }
 
+/* Return a copy of the command that also reports its outcome on the
+   returned channel once it has run. */
+func (cmd Command) WithResult() (Command, <-chan error) {
+  var done = make(chan error, 1)
+  var run = cmd.run
+  wrapped := func(cl *client) error {
+    err := run(cl)
+    done<- err
+    return err
+  }
+  return Command{run: wrapped}, done
+}
+
 func Ping() Command {
   run := func(cl *client) error {
     cl.notifier.Partial("Pinging all nodes playing on this game")
@@ -50,6 +85,21 @@ func Ping() Command {
     br := bufio.NewReader(rc)
     defer rc.Close()
     var nReady uint32
+    var results []PingResult
+    addResult := func(parts []string, ready bool) {
+      if len(parts) < 4 { return }
+      var res = PingResult{Ready: ready}
+      n, err := strconv.ParseUint(parts[1], 10, 32)
+      if err != nil { return }
+      res.Rank = uint32(n)
+      res.TeamKey = parts[2]
+      n, _ = strconv.ParseUint(parts[3], 10, 32)
+      res.BotId = uint32(n)
+      if ready && len(parts) > 4 {
+        res.LatencyMs, _ = strconv.ParseUint(parts[4], 10, 64)
+      }
+      results = append(results, res)
+    }
     for {
       var bs []byte
       bs, err = br.ReadBytes('\n')
@@ -60,20 +110,30 @@ func Ping() Command {
       if len(parts) == 0 { continue }
       switch parts[0] {
       case "timeout":
+        /* timeout RANK TEAM_KEY BOT_ID */
+        if len(parts) < 4 { continue }
         cl.notifier.Warningf("Player %s (%s #%s) is not ready",
-          parts[1], parts[2][0:8], parts[3])
+          parts[1], shortKey(parts[2]), parts[3])
+        addResult(parts, false)
       case "pong":
+        /* pong RANK TEAM_KEY BOT_ID LATENCY_MS */
+        if len(parts) < 5 { continue }
         cl.notifier.Partialf("Player %s (%s #%s) is ready, latency %sms",
-          parts[1], parts[2][0:8], parts[3], parts[4])
+          parts[1], shortKey(parts[2]), parts[3], parts[4])
+        addResult(parts, true)
         nReady += 1
       case "OK": {
         plural := ""
         if nReady != 1 { plural = "s" }
         cl.notifier.Finalf("Ready with %d bot%s", nReady, plural)
+        cl.setPingResults(results)
+        cl.emitRound()
         return nil
       }
       case "ERROR":
         cl.notifier.Final("\nSome bots are not ready\n")
+        cl.setPingResults(results)
+        cl.emitRound()
         return nil
       }
     }
@@ -82,6 +142,39 @@ func Ping() Command {
   return Command{run: run}
 }
 
+/* The first characters of a team key, enough to tell teams apart. */
+func shortKey(key string) string {
+  if len(key) > 8 { return key[0:8] }
+  return key
+}
+
+/* Outcome of a ping for one player. */
+type PingResult struct {
+  Rank uint32
+  TeamKey string
+  BotId uint32
+  Ready bool
+  LatencyMs uint64
+}
+
+func (cl *client) setPingResults(results []PingResult) {
+  var ranks = make([]uint32, len(results))
+  for i, res := range results {
+    ranks[i] = res.Rank
+  }
+  cl.setPlayers(ranks)
+  cl.resultsMutex.Lock()
+  cl.pingResults = results
+  cl.resultsMutex.Unlock()
+}
+
+/* Results of the last ping. */
+func (cl *client) PingResults() []PingResult {
+  cl.resultsMutex.Lock()
+  defer cl.resultsMutex.Unlock()
+  return append([]PingResult(nil), cl.pingResults...)
+}
+
 func AlwaysSendCommands() Command {
   run := func(cl *client) error {
     if len(cl.bots) == 0 {
@@ -97,6 +190,52 @@ func AlwaysSendCommands() Command {
   return Command{run: run}
 }
 
+/* Replace the configured bots, and register them if a game is loaded. */
+func SetBots(bots []BotConfig) Command {
+  run := func(cl *client) error {
+    if sameBotIds(cl.bots, bots) {
+      /* Only the commands changed, the bots keep their players. */
+      cl.setBots(bots)
+      cl.notifier.Finalf("Updated %d bots, effective next round", len(bots))
+      return nil
+    }
+    cl.setBots(bots)
+    cl.setBotRanks(nil)
+    cl.botsRegistered = false
+    if cl.game == nil { return nil }
+    err := cl.registerBots()
+    if err != nil { return err }
+    cl.notifier.Finalf("Registered %d bots", len(cl.botRanks))
+    return nil
+  }
+  return Command{run: run}
+}
+
+/* Change how the bots' standard error is kept and shown. */
+func SetStderr(cfg StderrConfig) Command {
+  run := func(cl *client) error {
+    cl.stderrConfig = cfg
+    return nil
+  }
+  return Command{run: run}
+}
+
+func sameBotIds(a []BotConfig, b []BotConfig) bool {
+  if len(a) != len(b) { return false }
+  for i := range a {
+    if a[i].Id != b[i].Id { return false }
+  }
+  return true
+}
+
+/* Does nothing; used to wait for the worker to become idle. */
+func Noop() Command {
+  run := func(cl *client) error {
+    return nil
+  }
+  return Command{run: run}
+}
+
 func Sync() Command {
   run := func(cl *client) error {
     _, err := cl.syncGame()
@@ -109,8 +248,14 @@ func SyncThenSendCommands() Command {
   run := func(cl *client) error {
     var err error
     var currentRound uint64
-    currentRound, err = cl.syncGame()
+    var current bool
+    currentRound, current, err = cl.syncLatestBlock()
     if err != nil { return err }
+    if !current {
+      cl.notifier.Warningf("Block %s is not current, waiting for the next one",
+        cl.getLatestBlock())
+      return nil
+    }
     if cl.roundCommandsOk != currentRound {
       err = cl.sendCommands(currentRound)
       if err != nil { return err }
@@ -126,25 +271,145 @@ type BotFeedback struct {
   Round uint64
   Rank uint32
   Err error
+  Duration time.Duration
+  Commands string
+  At time.Time
+}
+
+/* Status of a bot's last run. */
+const (
+  BotReady = "ready"       /* commands accepted by the server */
+  BotFailed = "failed"     /* the bot command failed */
+  BotTooSlow = "too slow"  /* commands arrived after the end of the block */
+  BotRejected = "rejected" /* commands rejected by the server */
+)
+
+/* Disable a bot (it sends nothing, or runs its fallback command) or
+   enable it again, from the next run on. */
+func (cl *client) SetBotEnabled(botId uint32, enabled bool) {
+  cl.botsMutex.Lock()
+  defer cl.botsMutex.Unlock()
+  if cl.disabledBots == nil {
+    cl.disabledBots = make(map[uint32]bool)
+  }
+  if enabled {
+    delete(cl.disabledBots, botId)
+  } else {
+    cl.disabledBots[botId] = true
+  }
+}
+
+func (cl *client) BotEnabled(botId uint32) bool {
+  cl.botsMutex.Lock()
+  defer cl.botsMutex.Unlock()
+  return !cl.disabledBots[botId]
+}
+
+func (cl *client) setBotResult(fb BotFeedback) {
+  fb.At = time.Now()
+  cl.resultsMutex.Lock()
+  if cl.botResults == nil {
+    cl.botResults = make(map[uint32]BotFeedback)
+  }
+  cl.botResults[fb.Bot.Id] = fb
+  cl.resultsMutex.Unlock()
+}
+
+/* Outcome of the last run of each bot, ordered by bot id. */
+func (cl *client) BotResults() []BotFeedback {
+  cl.resultsMutex.Lock()
+  defer cl.resultsMutex.Unlock()
+  var res = make([]BotFeedback, 0, len(cl.botResults))
+  for _, fb := range cl.botResults {
+    res = append(res, fb)
+  }
+  sort.Slice(res, func (i, j int) bool { return res[i].Bot.Id < res[j].Bot.Id })
+  return res
 }
 
 func (cl *client) sendCommands(currentRound uint64) error {
   var err error
-  cl.notifier.Final(fmt.Sprintf("Sending commands for round %d", currentRound))
+  switch cl.Phase() {
+  case PhaseLocked:
+    cl.notifier.Warning("Game is locked, not sending commands")
+    return nil
+  case PhaseEnded:
+    cl.notifier.Warning("Game has ended, not sending commands")
+    return nil
+  }
+  cl.notifier.Log(notify.Info, fmt.Sprintf("Sending commands for round %d", currentRound),
+    cl.fields(notify.Fields{"round": currentRound}))
   var retry bool
   for {
     retry, err = cl.trySendCommands(currentRound)
-    if err == nil {
+    if err == nil && !retry {
       cl.roundCommandsOk = currentRound
     }
     if !retry {
       return err
     }
-    currentRound, err = cl.syncGame()
+    var current bool
+    currentRound, current, err = cl.syncLatestBlock()
     if err != nil {
       return err
     }
+    if !current {
+      cl.notifier.Warningf("Block %s is not current, waiting for the next one",
+        cl.getLatestBlock())
+      return nil
+    }
+  }
+}
+
+/* The last block, if it is a command block (it is not in the first
+   round). */
+func (cl *client) previousBlock() *api.CommandBlock {
+  var block api.CommandBlock
+  err := cl.store.ReadBlock(cl.game.LastBlock, &block)
+  if err != nil || block.Commands == nil { return nil }
+  return &block
+}
+
+/* The part of the bots' environment that is common to all bots. */
+func (cl *client) roundEnv(roundNumber uint64) CommandEnv {
+  blockDir := cl.store.BlockDir(cl.game.LastBlock)
+  env := CommandEnv{
+    RoundNumber: roundNumber,
+    NbCycles: cl.game.NbCyclesPerRound,
+    GameKey: cl.game.Key,
+    TeamKey: cl.teamKeyPair.Public,
+    LastBlock: cl.game.LastBlock,
+    BlockDir: blockDir,
+    StatePath: filepath.Join(blockDir, "state.json"),
+  }
+  if cl.game.RoundEndsAt != nil {
+    env.RoundDeadline = *cl.game.RoundEndsAt
   }
+  if cl.previousBlock() != nil {
+    env.PreviousBlockPath = filepath.Join(blockDir, "block.json")
+  }
+  params, err := cl.gameParams()
+  if err != nil {
+    cl.notifier.Warningf("Game parameters unavailable: %v", err)
+  } else {
+    env.GameParams = params
+  }
+  return env
+}
+
+/* Complete a bot run's record with its outcome and add it to the history. */
+func (cl *client) saveRecord(record *history.Record, feedback BotFeedback) {
+  if cl.history == nil { return }
+  record.Status = feedback.Status
+  if feedback.Err != nil {
+    record.Error = feedback.Err.Error()
+  }
+  if apiErr, ok := api.AsApiError(feedback.Err); ok {
+    record.ServerError = apiErr.Message
+    record.ServerDetails = apiErr.Details
+  }
+  err := cl.history.Write(record)
+  if err != nil { cl.notifier.Error(err) }
 }
 
 func (cl *client) trySendCommands(roundNumber uint64) (bool, error) {
@@ -152,18 +417,21 @@ func (cl *client) trySendCommands(roundNumber uint64) (bool, error) {
   var log *os.File
   var lastError error
 
-  log, err = os.OpenFile("commands.log", os.O_CREATE|os.O_TRUNC|os.O_WRONLY,
+  log, err = os.OpenFile(cl.workspace.CommandsLogPath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY,
     0644)
   if err != nil {
-    cl.notifier.Error(errors.New("failed to write commands.log"))
+    cl.notifier.Error(fmt.Errorf("failed to write %s", cl.workspace.CommandsLogPath()))
     log = nil
   }
   if log != nil {
     defer log.Close()
+    log.WriteString(fmt.Sprintf("\n=== Game: %s\n", cl.game.Key))
     log.WriteString(fmt.Sprintf("Round: %d\n", roundNumber))
     log.WriteString(fmt.Sprintf("NbCycles: %d\n", cl.game.NbCyclesPerRound))
   }
 
+  roundEnv := cl.roundEnv(roundNumber)
+  previous := cl.previousBlock()
   for i := range cl.bots {
     bot := &cl.bots[i]
     if i >= len(cl.botRanks) {
@@ -172,25 +440,80 @@ func (cl *client) trySendCommands(roundNumber uint64) (bool, error) {
     }
     rank := cl.botRanks[i]
 
-    fmt.Printf("--- START bot id %d --- player %d --- round %d ---\n",
-      bot.Id, rank, roundNumber)
+    /* Do not run bots against a block that is already superseded, start
+       over on the latest block instead. */
+    if cl.blockSuperseded() {
+      if log != nil {
+        log.WriteString("\nBlock was superseded, skipping to the latest block.\n")
+      }
+      cl.notifier.Warningf("Round %d was superseded", roundNumber)
+      return true, nil
+    }
+
+    botFields := cl.fields(notify.Fields{"bot": bot.Id, "player": rank, "round": roundNumber})
+    command := bot.Command
+    if !cl.BotEnabled(bot.Id) {
+      if bot.Fallback == "" {
+        cl.notifier.Log(notify.Info, fmt.Sprintf("--- SKIP bot id %d (disabled) ---", bot.Id), botFields)
+        continue
+      }
+      command = bot.Fallback
+      cl.notifier.Log(notify.Info, fmt.Sprintf("Bot id %d is disabled, running its fallback", bot.Id), botFields)
+    }
+    cl.notifier.Log(notify.Info, fmt.Sprintf("--- START bot id %d --- player %d --- round %d ---",
+      bot.Id, rank, roundNumber), botFields)
     if log != nil {
       log.WriteString(fmt.Sprintf("\n--- Player %d BotId %d ---\n", rank, bot.Id))
     }
 
-    var commands string
-    commands, err = runCommand(bot.Command, CommandEnv{
+    var run *CommandRun
+    var startTime = time.Now()
+    env := roundEnv
+    env.BotId = bot.Id
+    env.PlayerNumber = rank
+    env.PreviousCommands = PlayerCommands(previous, rank)
+    run, err = runCommand(command, cl.workspace.Dir, bot.Input, env, cl.stderrConfig)
+    commands := run.Stdout
+    botLabel := strconv.FormatUint(uint64(bot.Id), 10)
+    metrics.BotRunDuration.WithLabelValues(botLabel).Observe(time.Since(startTime).Seconds())
+    metrics.BotRuns.WithLabelValues(botLabel, exitStatus(err)).Inc()
+    feedback := BotFeedback{
+      Bot: bot,
+      Round: roundNumber,
+      Rank: rank,
+      Duration: time.Since(startTime),
+      Commands: commands,
+    }
+    record := history.Record{
+      GameKey: cl.game.Key,
+      Round: roundNumber,
+      Block: cl.game.LastBlock,
       BotId: bot.Id,
-      RoundNumber: roundNumber,
-      PlayerNumber: rank,
-      NbCycles: cl.game.NbCyclesPerRound,
-    })
+      Player: rank,
+      Command: command,
+      Env: run.Env,
+      Input: run.Input,
+      Stdout: run.Stdout,
+      Stderr: run.Stderr,
+      ExitStatus: exitStatus(err),
+      StartedAt: startTime,
+      Duration: feedback.Duration,
+    }
     if err != nil {
       lastError = err
+      feedback.Status = BotFailed
+      feedback.Err = err
+      cl.setBotResult(feedback)
+      cl.saveRecord(&record, feedback)
       if log != nil {
         log.WriteString(fmt.Sprintf("\nError running bot: %v\n", err))
+        if run.Stderr != "" {
+          log.WriteString("--- stderr ---\n")
+          log.WriteString(run.Stderr)
+        }
       }
-      cl.notifier.Error(fmt.Errorf("Bot id %d error -- see commands.log", bot.Id))
+      cl.notifier.Log(notify.Error, fmt.Sprintf("Bot id %d error -- see %s", bot.Id, cl.workspace.CommandsLogPath()),
+        notify.Merge(botFields, notify.Fields{"error": err, "duration": feedback.Duration}))
       continue
     }
     if log != nil {
@@ -199,13 +522,22 @@ func (cl *client) trySendCommands(roundNumber uint64) (bool, error) {
 
     err = cl.remote.InputCommands(cl.game.Key, cl.game.LastBlock, bot.Id, commands)
     if err != nil {
-      if cl.remote.LastError == "current block has changed" {
+      feedback.Err = err
+      metrics.CommandRejections.WithLabelValues(rejectionReason(err)).Inc()
+      if isBlockChanged(err) {
+        feedback.Status = BotTooSlow
+        cl.setBotResult(feedback)
+        cl.saveRecord(&record, feedback)
         if log != nil {
           log.WriteString("\nCommands were sent after end of block, and ignored.\n")
         }
-        cl.notifier.Error(fmt.Errorf("Bot id %d was too slow", bot.Id))
+        cl.notifier.Log(notify.Error, fmt.Sprintf("Bot id %d was too slow", bot.Id),
+          notify.Merge(botFields, notify.Fields{"duration": feedback.Duration}))
         return true, err // retry
       }
+      feedback.Status = BotRejected
+      cl.setBotResult(feedback)
+      cl.saveRecord(&record, feedback)
       if log != nil {
         log.WriteString(fmt.Sprintf("\nError sending commands: %v\n", err))
       }
@@ -213,13 +545,47 @@ func (cl *client) trySendCommands(roundNumber uint64) (bool, error) {
       return false, err
     }
 
-    fmt.Printf("--- READY bot id %d --- player %d --- round %d ---\n",
-      bot.Id, rank, roundNumber)
+    feedback.Status = BotReady
+    cl.setBotResult(feedback)
+    record.Submitted = true
+    cl.saveRecord(&record, feedback)
+    metrics.CommandsSent.Inc()
+    cl.setSubmitted(rank)
+    cl.emitRound()
+
+    cl.notifier.Log(notify.Info, fmt.Sprintf("--- READY bot id %d --- player %d --- round %d ---",
+      bot.Id, rank, roundNumber),
+      notify.Merge(botFields, notify.Fields{"duration": feedback.Duration}))
   }
 
   return false, lastError
 }
 
+/* Error of the server when commands are sent for a past block. */
+const blockChangedError = "current block has changed"
+
+func isBlockChanged(err error) bool {
+  apiErr, ok := api.AsApiError(err)
+  return ok && apiErr.Message == blockChangedError
+}
+
+/* Label of a rejection in the metrics; the server's message is free text,
+   and would make an unbounded set of series. */
+func rejectionReason(err error) string {
+  apiErr, ok := api.AsApiError(err)
+  switch {
+  case !ok:
+    return "request_failed"
+  case apiErr.Message == blockChangedError:
+    return "block_changed"
+  /* The server explains invalid commands in the details. */
+  case apiErr.Details != "" || strings.Contains(strings.ToLower(apiErr.Message), "command"):
+    return "invalid_commands"
+  default:
+    return "other"
+  }
+}
+
 func EndOfRound() Command {
   run := func(cl *client) error {
     var err error
@@ -229,7 +595,46 @@ func EndOfRound() Command {
     cl.notifier.Partial(fmt.Sprintf("Closing round %d", currentRound))
     _, err = cl.remote.CloseRound(cl.game.Key, cl.game.LastBlock)
     if err != nil { return err }
-    cl.notifier.Final(fmt.Sprintf("Round %d is closed", currentRound))
+    cl.notifier.Log(notify.Info, fmt.Sprintf("Round %d is closed", currentRound),
+      cl.fields(notify.Fields{"round": currentRound}))
+    return nil
+  }
+  return Command{run: run}
+}
+
+/* Record the end of the game and bring the store up to date with the final
+   state. */
+func EndOfGame(reason string) Command {
+  run := func(cl *client) error {
+    cl.setPhase(PhaseEnded)
+    if cl.game == nil { return nil }
+    cl.notifier.Partial("Retrieving final game state")
+    game, err := cl.remote.ShowGame(cl.game.Key)
+    if err != nil { return err }
+    cl.setGame(game)
+    if !cl.watching {
+      err = cl.saveGame()
+      if err != nil { return err }
+    }
+    cl.notifier.Partial("Retrieving blocks")
+    err = cl.store.GetChain(cl.game.FirstBlock, cl.game.LastBlock)
+    if err != nil { return err }
+    if reason == "" {
+      cl.notifier.Final("Game over")
+    } else {
+      cl.notifier.Finalf("Game over (%s)", reason)
+    }
+    return nil
+  }
+  return Command{run: run}
+}
+
+/* Switch to another game, used to follow up on a finished game. */
+func JoinGame(gameKey string) Command {
+  run := func(cl *client) error {
+    err := cl.JoinGame(gameKey)
+    if err != nil { return err }
+    cl.notifier.Final("Game joined")
     return nil
   }
   return Command{run: run}
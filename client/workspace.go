@@ -0,0 +1,40 @@
+package client
+
+import (
+  "path/filepath"
+)
+
+/* Where a client keeps its files.  Relative paths are taken from Dir,
+   which is also the working directory of the bots; an empty Dir is the
+   current directory. */
+type Workspace struct {
+  Dir string
+  StateFile string /* the current game */
+  CommandsLog string /* the bots' commands and errors */
+  ProtocolIntf string /* protocol.mli, sent when creating a game */
+  ProtocolImpl string /* protocol.ml */
+}
+
+func NewWorkspace(dir string) Workspace {
+  return Workspace{
+    Dir: dir,
+    StateFile: "game.json",
+    CommandsLog: "commands.log",
+    ProtocolIntf: "protocol.mli",
+    ProtocolImpl: "protocol.ml",
+  }
+}
+
+/* Resolve a path relative to the workspace. */
+func (w Workspace) Path(name string) string {
+  if filepath.IsAbs(name) { return name }
+  return filepath.Join(w.Dir, name)
+}
+
+func (w Workspace) StatePath() string {
+  return w.Path(w.StateFile)
+}
+
+func (w Workspace) CommandsLogPath() string {
+  return w.Path(w.CommandsLog)
+}
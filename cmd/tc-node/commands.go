@@ -0,0 +1,525 @@
+package main
+
+import (
+  "encoding/json"
+  "errors"
+  "flag"
+  "fmt"
+  "os"
+  "path/filepath"
+  "time"
+  "tezos-contests.izibi.com/backend/signing"
+  "tezos-contests.izibi.com/tc-node/api"
+  "tezos-contests.izibi.com/tc-node/block_store"
+  "tezos-contests.izibi.com/tc-node/client"
+  "tezos-contests.izibi.com/tc-node/history"
+  "tezos-contests.izibi.com/tc-node/notify"
+)
+
+/* Exit codes. */
+const (
+  exitOk = 0
+  exitFailure = 1
+  exitUsage = 2
+)
+
+type Subcommand struct {
+  Name string
+  Args string
+  Help string
+  Run func(args []string) int
+}
+
+var subcommands []Subcommand
+
+func init() {
+  subcommands = []Subcommand{
+    {"run", "[--daemon]", "play the current game (the default command)", runCommand},
+    {"new", "[--daemon]", "create a new game and play it", newCommand},
+    {"join", "[--daemon] GAME_KEY", "join a game and play it", joinCommand},
+    {"status", "[--json]", "show the current game", statusCommand},
+    {"leave", "[--json]", "forget the current game", leaveCommand},
+    {"ping", "[--json]", "ping the players of the current game", actionCommand("ping", client.Ping)},
+    {"sync", "[--json]", "download the latest blocks", actionCommand("sync", client.Sync)},
+    {"send", "[--json]", "run the bots and send their commands", actionCommand("send", client.AlwaysSendCommands)},
+    {"close-round", "[--json]", "end the current round", actionCommand("close-round", client.EndOfRound)},
+    {"key", "generate|show|verify|export-public|rotate|migrate", "manage the team's key pair", keyCommand},
+    {"store", "[--json] [path|clear]", "show or clear the block store", storeCommand},
+    {"history", "[-game KEY] [-json] [ROUND [BOT_ID]]", "show the bots' past runs", historyCommand},
+    {"replay", "[ROUND]", "step through the current game", replayCommand},
+    {"watch", "GAME_KEY", "follow a game without playing", watchCommand},
+    {"multi", "", "play the games listed in config.yaml", multiCommand},
+    {"help", "[COMMAND]", "show this help", helpCommand},
+  }
+}
+
+func findCommand(name string) (*Subcommand, bool) {
+  for i := range subcommands {
+    if subcommands[i].Name == name { return &subcommands[i], true }
+  }
+  return nil, false
+}
+
+func printUsage() {
+  out := flag.CommandLine.Output()
+  fmt.Fprintf(out, "Usage: tc-node [OPTIONS] [COMMAND [ARGS]]\n\nCommands:\n")
+  for _, sc := range subcommands {
+    fmt.Fprintf(out, "  %-12s %-36s %s\n", sc.Name, sc.Args, sc.Help)
+  }
+  fmt.Fprintf(out, "\nOptions:\n")
+  flag.PrintDefaults()
+}
+
+func helpCommand(args []string) int {
+  if len(args) == 0 {
+    flag.CommandLine.SetOutput(os.Stdout)
+    printUsage()
+    return exitOk
+  }
+  sc, ok := findCommand(args[0])
+  if !ok {
+    DangerFmt.Printf("Unknown command %q\n", args[0])
+    return exitUsage
+  }
+  fmt.Printf("Usage: tc-node %s %s\n  %s\n", sc.Name, sc.Args, sc.Help)
+  return exitOk
+}
+
+/* How messages are shown. */
+const (
+  outputTerminal = iota /* full-screen interface if possible */
+  outputPlain /* plain terminal messages */
+  outputLog /* log lines on stdout, for daemons */
+  outputJson /* only warnings and errors, on stderr: stdout has the result */
+)
+
+/* Choose the notifier, then load the configuration. */
+func startup(output int) error {
+  switch output {
+  case outputTerminal:
+    if !*plainFlag && isTerminal() {
+      tui = NewTUI()
+      notifier = tui
+    }
+  case outputLog:
+    notifier = NewLogNotifier(os.Stdout, "text", notify.Info)
+  case outputJson:
+    notifier = NewLogNotifier(os.Stderr, "text", notify.Warning)
+  }
+  notifier.Partial("Loading " + configPath())
+  err := Configure()
+  if err != nil {
+    notifier.Error(err)
+    return err
+  }
+  err = setupLogging()
+  if err != nil {
+    notifier.Error(err)
+    return err
+  }
+  return nil
+}
+
+/* Load the team's key pair, set up the API, the store and the game client,
+   and check the local time.  The key pair is created with "tc-node key
+   generate", as it must first be associated with a team in the contest's
+   web interface. */
+func connect(storeDir string) (*signing.KeyPair, error) {
+  var err error
+  notifier.Partial("Loading the team's keypair")
+  var teamKeyPair *signing.KeyPair
+  teamKeyPair, err = loadKeyPair(config.KeypairFilename)
+  if os.IsNotExist(err) {
+    err = fmt.Errorf("%s not found, create it with \"tc-node key generate\"",
+      config.KeypairFilename)
+  } else if err != nil {
+    err = fmt.Errorf("%s: %v", config.KeypairFilename, err)
+  }
+  if err != nil {
+    notifier.Error(err)
+    return nil, err
+  }
+  notifier.Final(fmt.Sprintf("Team key: %s", teamKeyPair.Public))
+
+  remote = api.New(config.ApiBaseUrl, config.ApiKey, teamKeyPair)
+  store = block_store.New(config.StoreBaseUrl, storeDir)
+  cl = client.New(notifier, config.Task, remote, store,
+    history.New(config.HistoryDir), workspace(), teamKeyPair, config.Bots)
+
+  notifier.Partial("Checking the local time")
+  err = checkTime()
+  if err != nil {
+    notifier.Error(err)
+    return nil, err
+  }
+  return teamKeyPair, nil
+}
+
+func connectEvents() (<-chan interface{}, error) {
+  notifier.Partial("Connecting to the event stream")
+  ech, err := cl.Connect()
+  if err != nil {
+    /* Through the notifier only, as stdout has the result in JSON mode. */
+    notifier.Error(fmt.Errorf("failed to connect to the event stream: %w", err))
+    notifier.Warning("Did you link your public key (above) to your team?")
+    return nil, err
+  }
+  return ech, nil
+}
+
+/* Load the configuration, checking that bots are configured. */
+func startupToPlay(output int) error {
+  err := startup(output)
+  if err != nil { return err }
+  err = requireBots()
+  if err != nil {
+    notifier.Error(err)
+    return err
+  }
+  return nil
+}
+
+/* Connect and load the current game; needBots is set by the commands that
+   run the bots. */
+func loadCurrentGame(output int, needBots bool) (<-chan interface{}, error) {
+  var err error
+  if needBots {
+    err = startupToPlay(output)
+  } else {
+    err = startup(output)
+  }
+  if err != nil { return nil, err }
+  _, err = connect(config.StoreCacheDir)
+  if err != nil { return nil, err }
+  ech, err := connectEvents()
+  if err != nil { return nil, err }
+  err = cl.LoadGame()
+  if err != nil {
+    notifier.Error(err)
+    notifier.Final("Use the new or join commands to recover.")
+    return nil, err
+  }
+  if cl.Game() == nil {
+    err = errors.New("no current game, use the new or join commands")
+    notifier.Error(err)
+    return nil, err
+  }
+  notifier.Final("Game loaded")
+  return ech, nil
+}
+
+/* Consume the events of a one-shot command: the event goroutine blocks
+   until its events are read, and would stop answering pings. */
+func discardEvents(ech <-chan interface{}) {
+  go func() {
+    for range ech {}
+  }()
+}
+
+/* Run the interactive or daemon loop on the loaded game. */
+func play(ech <-chan interface{}, daemon bool) int {
+  if daemon {
+    notifier.Final(fmt.Sprintf("Game key: %s", cl.Game().Key))
+    DaemonLoop(ech)
+  } else {
+    fmt.Printf("Game key: ")
+    GameKeyFmt.Println(cl.Game().Key)
+    InteractiveLoop(ech)
+  }
+  return exitOk
+}
+
+func parseDaemonFlags(name string, args []string) (bool, []string) {
+  fs := flag.NewFlagSet(name, flag.ExitOnError)
+  daemon := fs.Bool("daemon", false, "run without a keyboard, logging to stdout")
+  fs.Parse(args)
+  return *daemon, fs.Args()
+}
+
+func outputFor(daemon bool) int {
+  if daemon { return outputLog }
+  return outputTerminal
+}
+
+/* tc-node [run [--daemon]] */
+func runCommand(args []string) int {
+  daemon, rest := parseDaemonFlags("run", args)
+  if len(rest) != 0 { return usage("run") }
+  ech, err := loadCurrentGame(outputFor(daemon), true)
+  if err != nil { return exitFailure }
+  return play(ech, daemon)
+}
+
+/* tc-node new [--daemon] */
+func newCommand(args []string) int {
+  daemon, rest := parseDaemonFlags("new", args)
+  if len(rest) != 0 { return usage("new") }
+  if startupToPlay(outputFor(daemon)) != nil { return exitFailure }
+  if _, err := connect(config.StoreCacheDir); err != nil { return exitFailure }
+  ech, err := connectEvents()
+  if err != nil { return exitFailure }
+  err = cl.NewGame(config.NewGameParams)
+  if err != nil {
+    notifier.Error(err)
+    return exitFailure
+  }
+  notifier.Final("Game created")
+  return play(ech, daemon)
+}
+
+/* tc-node join [--daemon] GAME_KEY */
+func joinCommand(args []string) int {
+  daemon, rest := parseDaemonFlags("join", args)
+  if len(rest) != 1 { return usage("join") }
+  if startupToPlay(outputFor(daemon)) != nil { return exitFailure }
+  if _, err := connect(config.StoreCacheDir); err != nil { return exitFailure }
+  ech, err := connectEvents()
+  if err != nil { return exitFailure }
+  err = cl.JoinGame(rest[0])
+  if err != nil {
+    notifier.Error(err)
+    return exitFailure
+  }
+  notifier.Final("Game joined")
+  return play(ech, daemon)
+}
+
+/* tc-node watch GAME_KEY */
+func watchCommand(args []string) int {
+  if len(args) != 1 { return usage("watch") }
+  if startup(outputPlain) != nil { return exitFailure }
+  if err := api.CheckGameKey(args[0]); err != nil {
+    notifier.Error(err)
+    return exitFailure
+  }
+  /* Keep the watched game's blocks apart from the played game's. */
+  storeDir := filepath.Join(config.StoreCacheDir, "watch", args[0])
+  if _, err := connect(storeDir); err != nil { return exitFailure }
+  ech, err := connectEvents()
+  if err != nil { return exitFailure }
+  err = cl.WatchGame(args[0])
+  if err != nil {
+    notifier.Error(err)
+    return exitFailure
+  }
+  notifier.Final("Game loaded")
+  WatchLoop(ech)
+  return exitOk
+}
+
+/* tc-node multi */
+func multiCommand(args []string) int {
+  if len(args) != 0 { return usage("multi") }
+  if startup(outputLog) != nil { return exitFailure }
+  teamKeyPair, err := connect(config.StoreCacheDir)
+  if err != nil { return exitFailure }
+  MultiLoop(teamKeyPair)
+  return exitOk
+}
+
+/* tc-node history ... */
+func historyCommand(args []string) int {
+  if startup(outputPlain) != nil { return exitFailure }
+  notifier.Final("")
+  return HistoryCommand(args)
+}
+
+/* tc-node replay [ROUND] */
+func replayCommand(args []string) int {
+  if startup(outputPlain) != nil { return exitFailure }
+  notifier.Final("")
+  return ReplayCommand(args)
+}
+
+func usage(name string) int {
+  sc, _ := findCommand(name)
+  DangerFmt.Printf("Usage: tc-node %s %s\n", sc.Name, sc.Args)
+  return exitUsage
+}
+
+func parseJsonFlag(name string, args []string) (bool, []string, bool) {
+  fs := flag.NewFlagSet(name, flag.ContinueOnError)
+  asJson := fs.Bool("json", false, "print the result as JSON")
+  err := fs.Parse(args)
+  return *asJson, fs.Args(), err == nil
+}
+
+func printJson(v interface{}) {
+  enc := json.NewEncoder(os.Stdout)
+  enc.SetIndent("", "  ")
+  enc.Encode(v)
+}
+
+func jsonOutput(asJson bool) int {
+  if asJson { return outputJson }
+  return outputPlain
+}
+
+/* Result of a non-interactive action, for --json. */
+type actionResult struct {
+  Ok bool `json:"ok"`
+  Error string `json:"error,omitempty"`
+  Details string `json:"details,omitempty"`
+  Game string `json:"game,omitempty"`
+  Bots []botResult `json:"bots,omitempty"`
+  Pings []pingResult `json:"pings,omitempty"`
+}
+
+/* Run a worker command once on the current game:
+   tc-node ping|sync|send|close-round [--json] */
+func actionCommand(name string, newCommand func() client.Command) func([]string) int {
+  return func(args []string) int {
+    asJson, rest, ok := parseJsonFlag(name, args)
+    if !ok || len(rest) != 0 { return usage(name) }
+    ech, err := loadCurrentGame(jsonOutput(asJson), name == "send")
+    if err != nil {
+      if asJson { printJson(actionResult{Error: err.Error()}) }
+      return exitFailure
+    }
+    discardEvents(ech)
+    wch, _ := cl.Worker()
+    cmd, done := newCommand().WithResult()
+    wch<- cmd
+    err = <-done
+    r := errorResult(err)
+    res := actionResult{Ok: r.Ok, Error: r.Error, Details: r.Details}
+    res.Game = cl.Game().Key
+    switch name {
+    case "ping":
+      res.Pings = pingResults()
+    case "send":
+      res.Bots = botResults()
+    }
+    if asJson {
+      printJson(res)
+    } else if err == nil {
+      notifier.Final(fmt.Sprintf("%s: done", name))
+    }
+    if err != nil { return exitFailure }
+    return exitOk
+  }
+}
+
+/* tc-node status [--json] */
+func statusCommand(args []string) int {
+  asJson, rest, ok := parseJsonFlag("status", args)
+  if !ok || len(rest) != 0 { return usage("status") }
+  type Status struct {
+    Game string `json:"game"`
+    Phase string `json:"phase"`
+    Round uint32 `json:"round"`
+    LastBlock string `json:"lastBlock"`
+    Deadline *time.Time `json:"deadline,omitempty"`
+    BotRanks map[uint32]uint32 `json:"botRanks"`
+    WatchUrl string `json:"watchUrl"`
+  }
+  ech, err := loadCurrentGame(jsonOutput(asJson), false)
+  if err != nil {
+    if asJson { printJson(actionResult{Error: err.Error()}) }
+    return exitFailure
+  }
+  discardEvents(ech)
+  game := cl.Game()
+  status := Status{
+    Game: game.Key,
+    Phase: cl.Phase().String(),
+    Round: game.CurrentRound,
+    LastBlock: game.LastBlock,
+    BotRanks: make(map[uint32]uint32),
+    WatchUrl: watchUrl(game.Key),
+  }
+  if deadline, ok := cl.RoundDeadline(); ok { status.Deadline = &deadline }
+  ranks := cl.BotRanks()
+  for i, bot := range config.Bots {
+    if i < len(ranks) { status.BotRanks[bot.Id] = ranks[i] }
+  }
+  if asJson {
+    printJson(status)
+    return exitOk
+  }
+  fmt.Print("Game:       ")
+  GameKeyFmt.Println(status.Game)
+  fmt.Printf("Phase:      %s\n", status.Phase)
+  fmt.Printf("Round:      %d\n", status.Round)
+  fmt.Printf("Last block: %s\n", status.LastBlock)
+  if status.Deadline != nil {
+    fmt.Printf("Deadline:   %s\n", status.Deadline.Format(time.RFC3339))
+  }
+  for _, bot := range config.Bots {
+    if rank, ok := status.BotRanks[bot.Id]; ok {
+      fmt.Printf("Bot id %d:   player %d\n", bot.Id, rank)
+    } else {
+      fmt.Printf("Bot id %d:   not playing\n", bot.Id)
+    }
+  }
+  fmt.Printf("Watch:      %s\n", status.WatchUrl)
+  return exitOk
+}
+
+/* tc-node leave [--json] */
+func leaveCommand(args []string) int {
+  asJson, rest, ok := parseJsonFlag("leave", args)
+  if !ok || len(rest) != 0 { return usage("leave") }
+  if startup(jsonOutput(asJson)) != nil { return exitFailure }
+  game, err := readGameFile()
+  if err == nil {
+    err = os.Remove(workspace().StatePath())
+  }
+  if asJson {
+    res := actionResult{Ok: err == nil}
+    if err != nil { res.Error = err.Error() } else { res.Game = game.Key }
+    printJson(res)
+  } else if err != nil {
+    notifier.Error(err)
+  } else {
+    notifier.Final(fmt.Sprintf("Left game %s", game.Key))
+  }
+  if err != nil { return exitFailure }
+  return exitOk
+}
+
+/* tc-node store [--json] [path|clear] */
+func storeCommand(args []string) int {
+  asJson, rest, ok := parseJsonFlag("store", args)
+  if !ok || len(rest) > 1 { return usage("store") }
+  if startup(jsonOutput(asJson)) != nil { return exitFailure }
+  st := block_store.New(config.StoreBaseUrl, config.StoreCacheDir)
+  action := ""
+  if len(rest) == 1 { action = rest[0] }
+  type Result struct {
+    Ok bool `json:"ok"`
+    Error string `json:"error,omitempty"`
+    Path string `json:"path"`
+    Blocks int `json:"blocks"`
+  }
+  var res = Result{Path: st.BlocksDir}
+  var err error
+  switch action {
+  case "":
+    err = st.Load()
+    res.Blocks = st.Index.Len()
+  case "path":
+  case "clear":
+    err = st.Clear()
+  default:
+    return usage("store")
+  }
+  res.Ok = err == nil
+  if err != nil { res.Error = err.Error() }
+  if asJson {
+    printJson(res)
+  } else if err != nil {
+    notifier.Error(err)
+  } else {
+    switch action {
+    case "":
+      fmt.Printf("%s (%d blocks)\n", res.Path, res.Blocks)
+    case "path":
+      fmt.Println(res.Path)
+    case "clear":
+      notifier.Final("Store cleared")
+    }
+  }
+  if err != nil { return exitFailure }
+  return exitOk
+}
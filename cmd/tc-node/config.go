@@ -0,0 +1,408 @@
+package main
+
+import (
+  "flag"
+  "fmt"
+  "io/ioutil"
+  "net"
+  "net/url"
+  "os"
+  "path/filepath"
+  "reflect"
+  "sort"
+  "strconv"
+  "strings"
+  "time"
+
+  "gopkg.in/yaml.v2"
+  "tezos-contests.izibi.com/tc-node/client"
+  "tezos-contests.izibi.com/tc-node/notify"
+)
+
+/* Prefix of the environment variables overriding configuration keys:
+   TC_NODE_API_KEY sets api_key, TC_NODE_AUTO_CLOSE_MARGIN sets
+   auto_close.margin, and so on. */
+const envPrefix = "TC_NODE_"
+
+var configFlag = flag.String("config", "config.yaml",
+  "read the configuration from `file`")
+var profileFlag = flag.String("profile", "",
+  "apply the profile `name` of the configuration file")
+var setFlags keyValueFlags
+
+func init() {
+  flag.Var(&setFlags, "set",
+    "override a configuration setting, e.g. -set api_key=KEY (repeatable)")
+}
+
+/* Repeatable key=value flag. */
+type keyValueFlags []string
+
+func (f *keyValueFlags) String() string {
+  return strings.Join(*f, ",")
+}
+
+func (f *keyValueFlags) Set(value string) error {
+  if !strings.Contains(value, "=") {
+    return fmt.Errorf("expected key=value, got %q", value)
+  }
+  *f = append(*f, value)
+  return nil
+}
+
+/* Path of the configuration file. */
+func configPath() string {
+  return *configFlag
+}
+
+/* Load the configuration.  Settings are taken, by increasing precedence,
+   from the configuration file, the selected profile, the TC_NODE_*
+   environment variables and the command-line flags; then the defaults are
+   filled in and the result is validated. */
+func Configure() error {
+  var err error
+  var configFile []byte
+  configFile, err = ioutil.ReadFile(configPath())
+  if err != nil { return err }
+  /* Boolean defaults are set before reading the file, so that an explicit
+     false is kept. */
+  config = Config{
+    AutoClose: client.AutoCloseConfig{AllSubmitted: true, Deadline: true},
+  }
+  err = yaml.UnmarshalStrict(configFile, &config)
+  if err != nil { return fmt.Errorf("%s: %v", configPath(), err) }
+  err = applyProfile()
+  if err != nil { return err }
+  err = applyEnvironment()
+  if err != nil { return err }
+  err = applyFlags()
+  if err != nil { return err }
+  applyDefaults()
+  err = validateConfig()
+  if err != nil { return err }
+  return resolvePaths()
+}
+
+/* Make the paths of the configuration absolute, so that tc-node can be
+   started from any directory: the workspace is relative to the directory
+   of the configuration file, and the other paths to the workspace. */
+func resolvePaths() error {
+  var err error
+  config.Workspace, err = workspaceDir()
+  if err != nil { return err }
+  info, err := os.Stat(config.Workspace)
+  if err != nil { return fmt.Errorf("workspace: %v", err) }
+  if !info.IsDir() { return fmt.Errorf("workspace: %s is not a directory", config.Workspace) }
+  ws := workspace()
+  for _, path := range []*string{&config.StoreCacheDir, &config.HistoryDir,
+      &config.KeypairFilename, &config.ProtocolIntf, &config.ProtocolImpl} {
+    *path = ws.Path(*path)
+  }
+  if config.LogFile != "" {
+    config.LogFile = ws.Path(config.LogFile)
+  }
+  return nil
+}
+
+/* Absolute path of the workspace. */
+func workspaceDir() (string, error) {
+  dir, err := filepath.Abs(filepath.Dir(configPath()))
+  if err != nil { return "", err }
+  if config.Workspace == "" { return dir, nil }
+  if filepath.IsAbs(config.Workspace) { return filepath.Clean(config.Workspace), nil }
+  return filepath.Join(dir, config.Workspace), nil
+}
+
+/* The workspace of the current game. */
+func workspace() client.Workspace {
+  ws := client.NewWorkspace(config.Workspace)
+  if config.ProtocolIntf != "" { ws.ProtocolIntf = config.ProtocolIntf }
+  if config.ProtocolImpl != "" { ws.ProtocolImpl = config.ProtocolImpl }
+  return ws
+}
+
+/* Overlay the selected profile (-profile, TC_NODE_PROFILE, or the profile
+   key of the file) on the settings of the file.  Maps such as
+   new_game_params are merged, lists such as bots are replaced. */
+func applyProfile() error {
+  name := config.Profile
+  if value, ok := os.LookupEnv(envPrefix + "PROFILE"); ok { name = value }
+  if *profileFlag != "" { name = *profileFlag }
+  config.Profile = name
+  if name == "" { return nil }
+  profile, ok := config.Profiles[name]
+  if !ok {
+    var names []string
+    for key := range config.Profiles {
+      names = append(names, key)
+    }
+    sort.Strings(names)
+    return fmt.Errorf("%s: unknown profile %q (profiles: %s)", configPath(), name,
+      strings.Join(names, ", "))
+  }
+  for _, item := range profile {
+    if key, _ := item.Key.(string); key == "profile" || key == "profiles" {
+      return fmt.Errorf("%s: profile %s cannot set %q", configPath(), name, key)
+    }
+  }
+  b, err := yaml.Marshal(profile)
+  if err != nil { return err }
+  err = yaml.UnmarshalStrict(b, &config)
+  if err != nil { return fmt.Errorf("%s: profile %s: %v", configPath(), name, err) }
+  return nil
+}
+
+/* Apply the TC_NODE_* environment variables. */
+func applyEnvironment() error {
+  for key, field := range configKeys() {
+    name := envPrefix + strings.ToUpper(strings.Replace(key, ".", "_", -1))
+    value, ok := os.LookupEnv(name)
+    if !ok { continue }
+    err := setConfigField(field, value)
+    if err != nil { return fmt.Errorf("%s: %v", name, err) }
+  }
+  return nil
+}
+
+/* Apply the -set flags, then the dedicated flags. */
+func applyFlags() error {
+  keys := configKeys()
+  for _, kv := range setFlags {
+    parts := strings.SplitN(kv, "=", 2)
+    field, ok := keys[parts[0]]
+    if !ok { return fmt.Errorf("-set: unknown setting %q", parts[0]) }
+    err := setConfigField(field, parts[1])
+    if err != nil { return fmt.Errorf("-set %s: %v", parts[0], err) }
+  }
+  flag.Visit(func (f *flag.Flag) {
+    switch f.Name {
+    case "auto-close":
+      config.AutoClose.Enabled = *autoCloseFlag
+    case "close-margin":
+      config.AutoClose.Margin = *closeMarginFlag
+    case "control":
+      config.Control.Listen = *controlFlag
+    case "log-file":
+      config.LogFile = *logFileFlag
+    }
+  })
+  return nil
+}
+
+/* The scalar settings that can be overridden, by dotted yaml key. */
+func configKeys() map[string]reflect.Value {
+  var res = make(map[string]reflect.Value)
+  collectKeys(reflect.ValueOf(&config).Elem(), "", res)
+  return res
+}
+
+func collectKeys(v reflect.Value, prefix string, res map[string]reflect.Value) {
+  t := v.Type()
+  for i := 0; i < t.NumField(); i++ {
+    name := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+    if name == "" || name == "-" { continue }
+    field := v.Field(i)
+    switch field.Kind() {
+    case reflect.Struct:
+      collectKeys(field, prefix + name + ".", res)
+    case reflect.String, reflect.Bool, reflect.Int, reflect.Int64,
+        reflect.Uint32, reflect.Uint64:
+      res[prefix + name] = field
+    }
+  }
+}
+
+func setConfigField(field reflect.Value, value string) error {
+  switch field.Kind() {
+  case reflect.String:
+    field.SetString(value)
+  case reflect.Bool:
+    b, err := strconv.ParseBool(value)
+    if err != nil { return fmt.Errorf("bad boolean %q", value) }
+    field.SetBool(b)
+  case reflect.Int64:
+    if field.Type() == reflect.TypeOf(time.Duration(0)) {
+      d, err := time.ParseDuration(value)
+      if err != nil { return fmt.Errorf("bad duration %q", value) }
+      field.SetInt(int64(d))
+      return nil
+    }
+    fallthrough
+  case reflect.Int:
+    n, err := strconv.ParseInt(value, 10, 64)
+    if err != nil { return fmt.Errorf("bad integer %q", value) }
+    field.SetInt(n)
+  case reflect.Uint32, reflect.Uint64:
+    n, err := strconv.ParseUint(value, 10, 64)
+    if err != nil { return fmt.Errorf("bad integer %q", value) }
+    field.SetUint(n)
+  }
+  return nil
+}
+
+func applyDefaults() {
+  if config.ApiBaseUrl == "" && config.BaseUrl != "" {
+    config.ApiBaseUrl = config.BaseUrl + "/backend"
+  }
+  if config.StoreBaseUrl == "" && config.BaseUrl != "" {
+    config.StoreBaseUrl = config.BaseUrl + "/backend/Blocks"
+  }
+  if config.WatchGameUrl == "" && config.BaseUrl != "" {
+    config.WatchGameUrl = config.BaseUrl + "/games"
+  }
+  if config.StoreCacheDir == "" {
+    config.StoreCacheDir = "store"
+  }
+  if config.HistoryDir == "" {
+    config.HistoryDir = "history"
+  }
+  if config.KeypairFilename == "" {
+    config.KeypairFilename = "team.json"
+  }
+  if config.ProtocolIntf == "" {
+    config.ProtocolIntf = "protocol.mli"
+  }
+  if config.ProtocolImpl == "" {
+    config.ProtocolImpl = "protocol.ml"
+  }
+  if config.ClockSyncInterval == 0 {
+    config.ClockSyncInterval = time.Minute
+  }
+  if config.ClockDriftThreshold == 0 {
+    config.ClockDriftThreshold = 500 * time.Millisecond
+  }
+  if config.LogFormat == "" {
+    config.LogFormat = "json"
+  }
+  if config.LogLevel == "" {
+    config.LogLevel = "info"
+  }
+  checkBots(config.Bots)
+  for i := range config.Games {
+    checkBots(config.Games[i].Bots)
+  }
+}
+
+/* Fill in the bot defaults. */
+func checkBots(bots []client.BotConfig) {
+  for i := range bots {
+    if bots[i].Input == "" { bots[i].Input = client.InputText }
+  }
+}
+
+/* Problems found in the configuration. */
+type configErrors []string
+
+func (e *configErrors) add(format string, a ...interface{}) {
+  *e = append(*e, fmt.Sprintf(format, a...))
+}
+
+/* Check the settings, reporting all the problems at once. */
+func validateConfig() error {
+  var errs configErrors
+  if config.BaseUrl == "" && (config.ApiBaseUrl == "" || config.StoreBaseUrl == "") {
+    errs.add("base_url: missing (or set both api_base and store_base)")
+  }
+  checkUrl(&errs, "base_url", config.BaseUrl)
+  checkUrl(&errs, "api_base", config.ApiBaseUrl)
+  checkUrl(&errs, "store_base", config.StoreBaseUrl)
+  checkUrl(&errs, "watch_game_url", config.WatchGameUrl)
+  if config.ApiKey == "" {
+    errs.add("api_key: missing")
+  }
+  if config.Task == "" {
+    errs.add("task: missing")
+  }
+  if config.ClockSyncInterval < 0 {
+    errs.add("clock_sync_interval: must not be negative")
+  }
+  if config.ClockDriftThreshold < 0 {
+    errs.add("clock_drift_threshold: must not be negative")
+  }
+  /* Checked even when disabled, as auto_close can be toggled at run time. */
+  if !config.AutoClose.AllSubmitted && !config.AutoClose.Deadline {
+    errs.add("auto_close: all_submitted and deadline are both false, rounds would never be closed")
+  }
+  if config.AutoClose.Margin < 0 {
+    errs.add("auto_close.margin: must not be negative")
+  }
+  if config.BotStderr.MaxSize < 0 {
+    errs.add("bot_stderr.max_size: must not be negative")
+  }
+  if config.LogFormat != "json" && config.LogFormat != "text" {
+    errs.add("log_format: must be \"json\" or \"text\", not %q", config.LogFormat)
+  }
+  if _, err := notify.ParseLevel(config.LogLevel); err != nil {
+    errs.add("log_level: %v", err)
+  }
+  if listen := config.Control.Listen; listen != "" && !strings.HasPrefix(listen, "unix:") {
+    if _, _, err := net.SplitHostPort(listen); err != nil {
+      errs.add("control.listen: must be host:port or unix:PATH, not %q", listen)
+    } else if !config.Control.AllowRemote && !isLoopbackAddr(listen) {
+      errs.add("control.listen: %q is not a loopback address, set control.allow_remote to listen on it", listen)
+    }
+  }
+  validateBots(&errs, "bots", config.Bots)
+  var names = make(map[string]bool)
+  var workspaces = make(map[string]bool)
+  base, err := workspaceDir()
+  if err != nil { return err }
+  for i, game := range config.Games {
+    where := fmt.Sprintf("games[%d]", i)
+    if game.Name == "" {
+      errs.add("%s: name is missing", where)
+    } else if names[game.Name] {
+      errs.add("%s: duplicate name %q", where, game.Name)
+    }
+    names[game.Name] = true
+    if game.Workspace != "" {
+      /* As the game's client will resolve it, from the main workspace. */
+      ws := client.Workspace{Dir: base}.Path(game.Workspace)
+      if resolved, err := filepath.EvalSymlinks(ws); err == nil { ws = resolved }
+      if workspaces[ws] {
+        errs.add("%s: workspace %q is shared with another game", where, game.Workspace)
+      }
+      workspaces[ws] = true
+    }
+    if len(game.Bots) == 0 {
+      errs.add("%s: no bots", where)
+    }
+    validateBots(&errs, where + ".bots", game.Bots)
+  }
+  if len(errs) == 0 { return nil }
+  return fmt.Errorf("invalid configuration in %s:\n  %s", configPath(),
+    strings.Join(errs, "\n  "))
+}
+
+func checkUrl(errs *configErrors, key string, value string) {
+  if value == "" { return }
+  u, err := url.Parse(value)
+  if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+    errs.add("%s: not an http(s) URL: %q", key, value)
+  }
+}
+
+func validateBots(errs *configErrors, key string, bots []client.BotConfig) {
+  var ids = make(map[uint32]bool)
+  for i, bot := range bots {
+    where := fmt.Sprintf("%s[%d]", key, i)
+    if ids[bot.Id] {
+      errs.add("%s: duplicate id %d", where, bot.Id)
+    }
+    ids[bot.Id] = true
+    if strings.TrimSpace(bot.Command) == "" {
+      errs.add("%s: command is missing", where)
+    }
+    if bot.Input != client.InputText && bot.Input != client.InputJson {
+      errs.add("%s: input must be \"text\" or \"json\", not %q", where, bot.Input)
+    }
+  }
+}
+
+/* Commands that play a game need bots. */
+func requireBots() error {
+  if len(config.Bots) == 0 {
+    return fmt.Errorf("no bots are configured in %s", configPath())
+  }
+  return nil
+}
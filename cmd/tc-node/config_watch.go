@@ -0,0 +1,34 @@
+package main
+
+import (
+  "os"
+  "time"
+)
+
+/* Interval between checks of config.yaml for changes. */
+const configPollInterval = 2 * time.Second
+
+/* Signal changes to a file, found by polling its modification time and
+   size.  The channel is never closed. */
+func watchFile(path string) <-chan struct{} {
+  ch := make(chan struct{}, 1)
+  go func() {
+    var lastTime time.Time
+    var lastSize int64
+    info, err := os.Stat(path)
+    if err == nil {
+      lastTime, lastSize = info.ModTime(), info.Size()
+    }
+    for range time.Tick(configPollInterval) {
+      info, err := os.Stat(path)
+      if err != nil { continue }
+      if info.ModTime().Equal(lastTime) && info.Size() == lastSize { continue }
+      lastTime, lastSize = info.ModTime(), info.Size()
+      select {
+      case ch<- struct{}{}:
+      default: /* a change is already pending */
+      }
+    }
+  }()
+  return ch
+}
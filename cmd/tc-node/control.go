@@ -0,0 +1,337 @@
+package main
+
+import (
+  "crypto/rand"
+  "crypto/subtle"
+  "encoding/hex"
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "net"
+  "net/http"
+  "os"
+  "path/filepath"
+  "strconv"
+  "strings"
+  "time"
+  "tezos-contests.izibi.com/tc-node/api"
+  "tezos-contests.izibi.com/tc-node/client"
+  "tezos-contests.izibi.com/tc-node/metrics"
+)
+
+/* Settings of the local control API. */
+type ControlConfig struct {
+  /* host:port (keep it on localhost), or unix:PATH for a Unix socket;
+     the control API is disabled if empty. */
+  Listen string `yaml:"listen"`
+  /* Also serve the web dashboard at / and its event stream at /events. */
+  Dashboard bool `yaml:"dashboard"`
+  /* Secret expected as "Authorization: Bearer TOKEN"; a random one is
+     made at each run, and saved in the workspace, if empty. */
+  Token string `yaml:"token"`
+  /* Accept a listen address that is not on the loopback interface. */
+  AllowRemote bool `yaml:"allow_remote"`
+}
+
+/* File of the workspace holding the control API's token. */
+const controlTokenFile = "control.token"
+
+/* Cookie set by the dashboard's /?token=TOKEN, so that the page and its
+   requests are authenticated without headers. */
+const controlCookie = "tc-node-token"
+
+/* Maximum time a control request waits for its command to complete. */
+const controlTimeout = 5 * time.Minute
+
+/* Commands that can be triggered through the control API. */
+var controlCommands = map[string]func() client.Command{
+  "ping": client.Ping,
+  "sync": client.Sync,
+  "sync-send": client.SyncThenSendCommands,
+  "send": client.AlwaysSendCommands,
+  "end-round": client.EndOfRound,
+}
+
+/* Serve the control API in the background:
+     POST /commands/{ping,sync,sync-send,send,end-round}
+     GET  /game
+     GET  /bots
+     POST /bots/{id}/{enable,disable}
+     GET  /pings
+     GET  /metrics (Prometheus)
+   and optionally the dashboard (GET / and /events).
+*/
+func StartControl(session *Session) error {
+  var err error
+  var listener net.Listener
+  listen := config.Control.Listen
+  isUnix := strings.HasPrefix(listen, "unix:")
+  if !isUnix && !config.Control.AllowRemote && !isLoopbackAddr(listen) {
+    return fmt.Errorf("control API: %s is not a loopback address, set control.allow_remote to listen on it", listen)
+  }
+  token := config.Control.Token
+  tokenPath := filepath.Join(config.Workspace, controlTokenFile)
+  if token == "" {
+    token, err = newControlToken(tokenPath)
+    if err != nil { return fmt.Errorf("control API: %v", err) }
+  }
+  if isUnix {
+    path := strings.TrimPrefix(listen, "unix:")
+    os.Remove(path) /* stale socket from a previous run */
+    listener, err = net.Listen("unix", path)
+  } else {
+    listener, err = net.Listen("tcp", listen)
+  }
+  if err != nil { return err }
+  mux := http.NewServeMux()
+  mux.HandleFunc("/commands/", session.controlCommand)
+  mux.HandleFunc("/game", controlGame)
+  mux.HandleFunc("/bots", controlBots)
+  mux.HandleFunc("/bots/", session.controlBot)
+  mux.HandleFunc("/pings", controlPings)
+  mux.Handle("/metrics", metrics.Handler())
+  if config.Control.Dashboard {
+    mux.HandleFunc("/", serveDashboard)
+    mux.Handle("/events", hub)
+  }
+  handler := &controlAuth{
+    handler: mux,
+    token: token,
+    checkHost: !isUnix && !config.Control.AllowRemote,
+  }
+  go func() {
+    err := http.Serve(listener, handler)
+    notifier.Error(fmt.Errorf("control API stopped: %v", err))
+  }()
+  notifier.Final("Control API listening on " + listen)
+  if config.Control.Token == "" {
+    notifier.Final("Control API token saved in " + tokenPath)
+  }
+  return nil
+}
+
+/* A random token, written where local tools can read it. */
+func newControlToken(path string) (string, error) {
+  b := make([]byte, 32)
+  _, err := rand.Read(b)
+  if err != nil { return "", err }
+  token := hex.EncodeToString(b)
+  os.Remove(path) /* so that the file is created with mode 0600 */
+  err = ioutil.WriteFile(path, []byte(token + "\n"), 0600)
+  if err != nil { return "", err }
+  return token, nil
+}
+
+/* Whether host:port is on the loopback interface. */
+func isLoopbackAddr(addr string) bool {
+  host, _, err := net.SplitHostPort(addr)
+  if err != nil { return false }
+  return isLoopbackHost(host)
+}
+
+func isLoopbackHost(host string) bool {
+  if host == "localhost" { return true }
+  ip := net.ParseIP(host)
+  return ip != nil && ip.IsLoopback()
+}
+
+/* Rejects the requests without the token, and cross-origin ones.  On a
+   loopback address, the Host header must also be a loopback name, so
+   that a page of another site cannot reach the API by rebinding its
+   domain to 127.0.0.1. */
+type controlAuth struct {
+  handler http.Handler
+  token string
+  checkHost bool
+}
+
+func (a *controlAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+  if a.checkHost {
+    host := r.Host
+    if h, _, err := net.SplitHostPort(host); err == nil { host = h }
+    if !isLoopbackHost(strings.Trim(host, "[]")) {
+      writeJson(w, http.StatusForbidden, controlResult{Error: "bad host " + r.Host})
+      return
+    }
+  }
+  if origin := r.Header.Get("Origin"); origin != "" && origin != "http://" + r.Host {
+    writeJson(w, http.StatusForbidden, controlResult{Error: "cross-origin request"})
+    return
+  }
+  if r.URL.Path == "/" && r.URL.Query().Get("token") != "" && a.valid(r.URL.Query().Get("token")) {
+    /* Opening the dashboard with its token. */
+    http.SetCookie(w, &http.Cookie{
+      Name: controlCookie, Value: a.token, Path: "/",
+      HttpOnly: true, SameSite: http.SameSiteStrictMode,
+    })
+    http.Redirect(w, r, "/", http.StatusSeeOther)
+    return
+  }
+  if !a.authorized(r) {
+    writeJson(w, http.StatusUnauthorized, controlResult{Error: "missing or wrong token"})
+    return
+  }
+  a.handler.ServeHTTP(w, r)
+}
+
+func (a *controlAuth) authorized(r *http.Request) bool {
+  if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+    return a.valid(strings.TrimPrefix(auth, "Bearer "))
+  }
+  if cookie, err := r.Cookie(controlCookie); err == nil {
+    return a.valid(cookie.Value)
+  }
+  return false
+}
+
+func (a *controlAuth) valid(token string) bool {
+  return subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) == 1
+}
+
+type controlResult struct {
+  Ok bool `json:"ok"`
+  Error string `json:"error,omitempty"`
+  Details string `json:"details,omitempty"`
+}
+
+func (s *Session) controlCommand(w http.ResponseWriter, r *http.Request) {
+  if r.Method != "POST" {
+    writeJson(w, http.StatusMethodNotAllowed, controlResult{Error: "use POST"})
+    return
+  }
+  name := strings.TrimPrefix(r.URL.Path, "/commands/")
+  newCommand, ok := controlCommands[name]
+  if !ok {
+    writeJson(w, http.StatusNotFound, controlResult{Error: "unknown command " + name})
+    return
+  }
+  cmd, done := newCommand().WithResult()
+  timeout := time.After(controlTimeout)
+  select {
+  case s.wch<- cmd:
+  case <-timeout:
+    writeJson(w, http.StatusServiceUnavailable, controlResult{Error: "worker is busy"})
+    return
+  }
+  select {
+  case err := <-done:
+    writeJson(w, http.StatusOK, errorResult(err))
+  case <-timeout:
+    writeJson(w, http.StatusGatewayTimeout, controlResult{Error: "command is still running"})
+  }
+}
+
+func errorResult(err error) controlResult {
+  if err == nil {
+    return controlResult{Ok: true}
+  }
+  if apiErr, ok := api.AsApiError(err); ok {
+    return controlResult{Error: apiErr.Message, Details: apiErr.Details}
+  }
+  return controlResult{Error: err.Error()}
+}
+
+func controlGame(w http.ResponseWriter, r *http.Request) {
+  type Response struct {
+    Game interface{} `json:"game"`
+    Phase string `json:"phase"`
+    Deadline *time.Time `json:"deadline,omitempty"`
+    ServerTime time.Time `json:"serverTime"`
+  }
+  /* Handlers run concurrently with the worker, read what it published. */
+  snap := cl.Snapshot()
+  var res = Response{
+    Game: snap.Game,
+    Phase: cl.Phase().String(),
+    ServerTime: cl.ServerTime(),
+  }
+  if !snap.Deadline.IsZero() { res.Deadline = &snap.Deadline }
+  writeJson(w, http.StatusOK, res)
+}
+
+type botResult struct {
+  BotId uint32 `json:"botId"`
+  Command string `json:"command"`
+  Status string `json:"status"`
+  Enabled bool `json:"enabled"`
+  Round uint64 `json:"round"`
+  Rank uint32 `json:"rank"`
+  Duration float64 `json:"duration"` /* seconds */
+  Commands string `json:"commands"`
+  Error string `json:"error,omitempty"`
+  At time.Time `json:"at"`
+}
+
+func botResults() []botResult {
+  var res = []botResult{}
+  for _, fb := range cl.BotResults() {
+    item := botResult{
+      BotId: fb.Bot.Id,
+      Command: fb.Bot.Command,
+      Status: fb.Status,
+      Enabled: cl.BotEnabled(fb.Bot.Id),
+      Round: fb.Round,
+      Rank: fb.Rank,
+      Duration: fb.Duration.Seconds(),
+      Commands: fb.Commands,
+      At: fb.At,
+    }
+    if fb.Err != nil { item.Error = fb.Err.Error() }
+    res = append(res, item)
+  }
+  return res
+}
+
+func controlBots(w http.ResponseWriter, r *http.Request) {
+  writeJson(w, http.StatusOK, botResults())
+}
+
+/* POST /bots/{id}/enable or /bots/{id}/disable */
+func (s *Session) controlBot(w http.ResponseWriter, r *http.Request) {
+  if r.Method != "POST" {
+    writeJson(w, http.StatusMethodNotAllowed, controlResult{Error: "use POST"})
+    return
+  }
+  parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/bots/"), "/")
+  if len(parts) != 2 || (parts[1] != "enable" && parts[1] != "disable") {
+    writeJson(w, http.StatusNotFound, controlResult{Error: "use /bots/{id}/enable or /bots/{id}/disable"})
+    return
+  }
+  botId, err := strconv.ParseUint(parts[0], 10, 32)
+  if err != nil {
+    writeJson(w, http.StatusBadRequest, controlResult{Error: "bad bot id " + parts[0]})
+    return
+  }
+  err = s.SetBotEnabled(uint32(botId), parts[1] == "enable")
+  if err != nil {
+    writeJson(w, http.StatusNotFound, errorResult(err))
+    return
+  }
+  writeJson(w, http.StatusOK, controlResult{Ok: true})
+}
+
+type pingResult struct {
+  Rank uint32 `json:"rank"`
+  TeamKey string `json:"teamKey"`
+  BotId uint32 `json:"botId"`
+  Ready bool `json:"ready"`
+  LatencyMs uint64 `json:"latencyMs"`
+}
+
+func pingResults() []pingResult {
+  var res = []pingResult{}
+  for _, ping := range cl.PingResults() {
+    res = append(res, pingResult(ping))
+  }
+  return res
+}
+
+func controlPings(w http.ResponseWriter, r *http.Request) {
+  writeJson(w, http.StatusOK, pingResults())
+}
+
+func writeJson(w http.ResponseWriter, status int, v interface{}) {
+  w.Header().Set("Content-Type", "application/json; charset=utf-8")
+  w.WriteHeader(status)
+  json.NewEncoder(w).Encode(v)
+}
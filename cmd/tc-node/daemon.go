@@ -0,0 +1,88 @@
+package main
+
+import (
+  "os"
+  "os/signal"
+  "reflect"
+  "syscall"
+  "time"
+  "tezos-contests.izibi.com/tc-node/client"
+)
+
+/* Interval between syncs in daemon mode, in case block events are lost. */
+const daemonSyncInterval = 30 * time.Second
+
+/* Time given to the worker to finish its current command on shutdown. */
+const shutdownTimeout = 30 * time.Second
+
+/* Drive the worker from events and timers only, without a keyboard.
+   SIGINT and SIGTERM stop the daemon once the worker is idle, SIGHUP
+   reloads config.yaml (which is also reloaded when it changes). */
+func DaemonLoop(ech <-chan interface{}) {
+  session := NewSession()
+  sigs := make(chan os.Signal, 1)
+  signal.Notify(sigs, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+  defer signal.Stop(sigs)
+  ticker := time.NewTicker(daemonSyncInterval)
+  defer ticker.Stop()
+  cch := watchFile(configPath())
+
+  session.wch<- client.AlwaysSendCommands()
+  for {
+    select {
+    case ev := <-ech:
+      if !session.HandleEvent(ev) { return }
+    case <-session.Deadline():
+      session.DeadlineReached()
+    case <-ticker.C:
+      cl.RequestSync()
+    case <-cch:
+      reloadConfig(session)
+    case sig := <-sigs:
+      if sig == syscall.SIGHUP {
+        reloadConfig(session)
+        continue
+      }
+      notifier.Final("Shutting down (" + sig.String() + ")")
+      if !session.Drain(shutdownTimeout) {
+        notifier.Warning("Worker did not finish in time")
+      }
+      return
+    }
+  }
+}
+
+/* Re-read config.yaml and apply the settings that can change while
+   running: bots, automatic end of round, end-of-game behaviour. */
+func reloadConfig(session *Session) {
+  notifier.Partial("Reloading " + configPath())
+  previous := config
+  err := Configure()
+  if err != nil {
+    config = previous
+    notifier.Error(err)
+    return
+  }
+  config.Latency = previous.Latency
+  config.TimeDelta = previous.TimeDelta
+  if config.BaseUrl != previous.BaseUrl || config.ApiBaseUrl != previous.ApiBaseUrl ||
+      config.ApiKey != previous.ApiKey || config.StoreCacheDir != previous.StoreCacheDir ||
+      config.KeypairFilename != previous.KeypairFilename || config.Task != previous.Task ||
+      config.Workspace != previous.Workspace {
+    notifier.Warning("Server, store and key settings only take effect on restart")
+  }
+  if !reflect.DeepEqual(config.Bots, previous.Bots) {
+    session.wch<- client.SetBots(config.Bots)
+  }
+  if config.BotStderr != previous.BotStderr {
+    session.wch<- client.SetStderr(botStderrConfig())
+  }
+  if config.AutoClose != previous.AutoClose {
+    if config.AutoClose.Enabled {
+      session.EnableAutoClose()
+    } else {
+      session.DisableAutoClose()
+    }
+  }
+  notifier.Final("Configuration reloaded")
+}
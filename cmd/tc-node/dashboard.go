@@ -0,0 +1,121 @@
+package main
+
+import (
+  "net/http"
+)
+
+/* The dashboard is a single page that polls the control API's read
+   endpoints whenever an event arrives on /events. */
+func serveDashboard(w http.ResponseWriter, r *http.Request) {
+  if r.URL.Path != "/" {
+    http.NotFound(w, r)
+    return
+  }
+  w.Header().Set("Content-Type", "text/html; charset=utf-8")
+  w.Write([]byte(dashboardHtml))
+}
+
+const dashboardHtml = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>tc-node</title>
+<style>
+  body { font-family: sans-serif; margin: 1em 2em; background: #fafafa; color: #222; }
+  h1 { font-size: 1.4em; }
+  h2 { font-size: 1.1em; margin-top: 1.5em; }
+  table { border-collapse: collapse; }
+  th, td { border: 1px solid #ccc; padding: 0.2em 0.6em; text-align: left; vertical-align: top; }
+  th { background: #eee; }
+  pre { margin: 0; max-height: 8em; overflow: auto; font-size: 0.85em; }
+  .ready { color: #080; } .failed, .rejected, .error { color: #c00; } .slow { color: #b60; }
+  #timer { font-size: 1.6em; font-weight: bold; }
+  #events { max-height: 20em; overflow: auto; font-family: monospace; font-size: 0.85em; }
+</style>
+</head>
+<body>
+<h1>tc-node <span id="key"></span></h1>
+<div>Phase: <b id="phase"></b> &mdash; round <b id="round"></b> &mdash; block <code id="block"></code></div>
+<div>Round ends in <span id="timer">&ndash;</span></div>
+<h2>Bots</h2>
+<table>
+  <thead><tr><th>Bot</th><th>Player</th><th>Round</th><th>Status</th><th>Duration</th><th>Output</th></tr></thead>
+  <tbody id="bots"></tbody>
+</table>
+<h2>Ping</h2>
+<table>
+  <thead><tr><th>Player</th><th>Team</th><th>Bot</th><th>Ready</th><th>Latency</th></tr></thead>
+  <tbody id="pings"></tbody>
+</table>
+<h2>Events</h2>
+<div id="events"></div>
+<script>
+var deadline = null;
+function $(id) { return document.getElementById(id); }
+function cell(tr, text, cls) {
+  var td = document.createElement("td");
+  td.textContent = text;
+  if (cls) td.className = cls;
+  tr.appendChild(td);
+  return td;
+}
+function refresh() {
+  fetch("/game").then(function (r) { return r.json(); }).then(function (res) {
+    var game = res.game || {};
+    $("key").textContent = game.key || "";
+    $("phase").textContent = res.phase;
+    $("round").textContent = game.currentRound;
+    $("block").textContent = game.lastBlock || "";
+    deadline = res.deadline ? new Date(res.deadline) : null;
+  });
+  fetch("/bots").then(function (r) { return r.json(); }).then(function (bots) {
+    var tbody = $("bots");
+    tbody.innerHTML = "";
+    (bots || []).forEach(function (bot) {
+      var tr = document.createElement("tr");
+      cell(tr, bot.botId);
+      cell(tr, bot.rank);
+      cell(tr, bot.round);
+      cell(tr, bot.status + (bot.error ? ": " + bot.error : ""), bot.status.replace(" ", "-").replace("too-", ""));
+      cell(tr, bot.duration.toFixed(2) + "s");
+      var pre = document.createElement("pre");
+      pre.textContent = bot.commands;
+      cell(tr, "").appendChild(pre);
+      tbody.appendChild(tr);
+    });
+  });
+  fetch("/pings").then(function (r) { return r.json(); }).then(function (pings) {
+    var tbody = $("pings");
+    tbody.innerHTML = "";
+    (pings || []).forEach(function (ping) {
+      var tr = document.createElement("tr");
+      cell(tr, ping.rank);
+      cell(tr, ping.teamKey.substring(0, 8));
+      cell(tr, ping.botId);
+      cell(tr, ping.ready ? "yes" : "no", ping.ready ? "ready" : "error");
+      cell(tr, ping.ready ? ping.latencyMs + "ms" : "");
+      tbody.appendChild(tr);
+    });
+  });
+}
+function tick() {
+  if (!deadline) { $("timer").textContent = "–"; return; }
+  var s = Math.max(0, Math.round((deadline - new Date()) / 1000));
+  $("timer").textContent = s + "s";
+}
+var source = new EventSource("/events");
+source.onmessage = function (e) {
+  var ev = JSON.parse(e.data);
+  var line = document.createElement("div");
+  line.textContent = new Date(ev.time).toLocaleTimeString() + " " + ev.type + " " + JSON.stringify(ev.data);
+  if (ev.type === "error") line.className = "error";
+  $("events").insertBefore(line, $("events").firstChild);
+  refresh();
+};
+refresh();
+setInterval(tick, 250);
+setInterval(refresh, 10000);
+</script>
+</body>
+</html>
+`
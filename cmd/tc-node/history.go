@@ -0,0 +1,186 @@
+package main
+
+import (
+  "encoding/json"
+  "flag"
+  "fmt"
+  "io/ioutil"
+  "strconv"
+  "strings"
+  "time"
+
+  "tezos-contests.izibi.com/tc-node/api"
+  "tezos-contests.izibi.com/tc-node/client"
+  "tezos-contests.izibi.com/tc-node/history"
+)
+
+/*
+  tc-node history [-game KEY] [-json]                list the recorded rounds
+  tc-node history [-game KEY] [-json] ROUND [BOT_ID] show a round's records
+  tc-node history -games                             list the recorded games
+
+  The game defaults to the current game (game.json).
+  Returns the process exit code.
+*/
+func HistoryCommand(args []string) int {
+  var err error
+  fs := flag.NewFlagSet("history", flag.ContinueOnError)
+  gameKey := fs.String("game", "", "show the history of game `key`")
+  listGames := fs.Bool("games", false, "list the games that have a history")
+  asJson := fs.Bool("json", false, "print the records as JSON")
+  err = fs.Parse(args)
+  if err != nil { return exitUsage }
+  h := history.New(config.HistoryDir)
+
+  if *listGames {
+    var keys []string
+    keys, err = h.Games()
+    if err != nil {
+      DangerFmt.Printf("%v\n", err)
+      return exitFailure
+    }
+    for _, key := range keys {
+      fmt.Println(key)
+    }
+    return exitOk
+  }
+
+  if *gameKey == "" {
+    *gameKey, err = currentGameKey()
+    if err != nil {
+      DangerFmt.Printf("No current game (%v), use -game KEY\n", err)
+      return exitFailure
+    }
+  }
+
+  if fs.NArg() == 0 {
+    return printRounds(h, *gameKey)
+  }
+  if fs.NArg() > 2 {
+    DangerFmt.Print("\nUsage: history [-game KEY] [-json] [ROUND [BOT_ID]]\n")
+    return exitUsage
+  }
+  round, err := strconv.ParseUint(fs.Arg(0), 10, 64)
+  if err != nil {
+    DangerFmt.Printf("bad round number %q\n", fs.Arg(0))
+    return exitUsage
+  }
+  records, err := h.Records(*gameKey, round)
+  if err != nil {
+    DangerFmt.Printf("%v\n", err)
+    return exitFailure
+  }
+  if fs.NArg() == 2 {
+    botId, err := strconv.ParseUint(fs.Arg(1), 10, 32)
+    if err != nil {
+      DangerFmt.Printf("bad bot id %q\n", fs.Arg(1))
+      return exitUsage
+    }
+    var selected []history.Record
+    for _, rec := range records {
+      if rec.BotId == uint32(botId) { selected = append(selected, rec) }
+    }
+    records = selected
+  }
+  if len(records) == 0 {
+    WarningFmt.Printf("No records for round %d of game %s\n", round, *gameKey)
+    return exitFailure
+  }
+  if *asJson {
+    bs, err := json.MarshalIndent(records, "", "  ")
+    if err != nil {
+      DangerFmt.Printf("%v\n", err)
+      return exitFailure
+    }
+    fmt.Println(string(bs))
+    return exitOk
+  }
+  for i := range records {
+    printRecord(&records[i])
+  }
+  return exitOk
+}
+
+func currentGameKey() (string, error) {
+  game, err := readGameFile()
+  if err != nil { return "", err }
+  return game.Key, nil
+}
+
+/* The current game, as last saved in the workspace's game.json. */
+func readGameFile() (*api.GameState, error) {
+  b, err := ioutil.ReadFile(workspace().StatePath())
+  if err != nil { return nil, err }
+  var game api.GameState
+  err = json.Unmarshal(b, &game)
+  if err != nil { return nil, err }
+  return &game, nil
+}
+
+/* One line per round, with the outcome of each bot. */
+func printRounds(h *history.History, gameKey string) int {
+  rounds, err := h.Rounds(gameKey)
+  if err != nil {
+    DangerFmt.Printf("%v\n", err)
+    return exitFailure
+  }
+  fmt.Print("Game ")
+  GameKeyFmt.Println(gameKey)
+  if len(rounds) == 0 {
+    NoticeFmt.Println("  no rounds recorded")
+    return exitOk
+  }
+  for _, round := range rounds {
+    records, err := h.Records(gameKey, round)
+    if err != nil {
+      DangerFmt.Printf("%v\n", err)
+      return exitFailure
+    }
+    var parts []string
+    for _, rec := range records {
+      part := fmt.Sprintf("bot %d", rec.BotId)
+      if rec.Attempt > 1 { part += fmt.Sprintf(" #%d", rec.Attempt) }
+      parts = append(parts, fmt.Sprintf("%s %s (%s)", part, rec.Status,
+        rec.Duration.Round(time.Millisecond)))
+    }
+    fmt.Printf("  round %d: %s\n", round, strings.Join(parts, ", "))
+  }
+  return exitOk
+}
+
+func printRecord(rec *history.Record) {
+  ImportantFmt.Printf("--- Round %d --- bot id %d --- player %d --- attempt %d ---\n",
+    rec.Round, rec.BotId, rec.Player, rec.Attempt)
+  fmt.Printf("Block:       %s\n", rec.Block)
+  fmt.Printf("Command:     %s\n", rec.Command)
+  fmt.Printf("Environment: %s\n", strings.Join(rec.Env, " "))
+  fmt.Printf("Started at:  %s\n", rec.StartedAt.Format(time.RFC3339))
+  fmt.Printf("Duration:    %s\n", rec.Duration.Round(time.Millisecond))
+  fmt.Printf("Exit status: %s\n", rec.ExitStatus)
+  switch rec.Status {
+  case client.BotReady:
+    SuccessFmt.Printf("Status:      %s\n", rec.Status)
+  default:
+    DangerFmt.Printf("Status:      %s\n", rec.Status)
+  }
+  if rec.Error != "" {
+    fmt.Printf("Error:       %s\n", rec.Error)
+  }
+  if rec.ServerError != "" {
+    fmt.Printf("Server:      %s\n", rec.ServerError)
+    if rec.ServerDetails != "" {
+      fmt.Printf("             %s\n", rec.ServerDetails)
+    }
+  }
+  printSection("input", rec.Input)
+  printSection("stdout", rec.Stdout)
+  printSection("stderr", rec.Stderr)
+  fmt.Println()
+}
+
+func printSection(name string, text string) {
+  if text == "" { return }
+  NoticeFmt.Printf("[%s]\n", name)
+  fmt.Print(text)
+  if !strings.HasSuffix(text, "\n") { fmt.Println() }
+}
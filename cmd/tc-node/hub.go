@@ -0,0 +1,82 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "sync"
+  "time"
+)
+
+/* Broadcasts events to the dashboard's SSE clients. */
+type EventHub struct {
+  mutex sync.Mutex
+  clients map[chan []byte]bool
+}
+
+var hub = NewEventHub()
+
+func NewEventHub() *EventHub {
+  return &EventHub{clients: make(map[chan []byte]bool)}
+}
+
+/* Send an event to all clients; slow clients miss events rather than
+   blocking the caller. */
+func (h *EventHub) Publish(kind string, data interface{}) {
+  type Message struct {
+    Type string `json:"type"`
+    Time time.Time `json:"time"`
+    Data interface{} `json:"data"`
+  }
+  bs, err := json.Marshal(Message{kind, time.Now(), data})
+  if err != nil { return }
+  h.mutex.Lock()
+  defer h.mutex.Unlock()
+  for ch := range h.clients {
+    select {
+    case ch<- bs:
+    default:
+    }
+  }
+}
+
+func (h *EventHub) subscribe() chan []byte {
+  ch := make(chan []byte, 16)
+  h.mutex.Lock()
+  h.clients[ch] = true
+  h.mutex.Unlock()
+  return ch
+}
+
+func (h *EventHub) unsubscribe(ch chan []byte) {
+  h.mutex.Lock()
+  delete(h.clients, ch)
+  h.mutex.Unlock()
+}
+
+func (h *EventHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+  flusher, ok := w.(http.Flusher)
+  if !ok {
+    http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+    return
+  }
+  w.Header().Set("Content-Type", "text/event-stream")
+  w.Header().Set("Cache-Control", "no-cache")
+  ch := h.subscribe()
+  defer h.unsubscribe(ch)
+  fmt.Fprintf(w, ": connected\n\n")
+  flusher.Flush()
+  keepAlive := time.NewTicker(15 * time.Second)
+  defer keepAlive.Stop()
+  for {
+    select {
+    case bs := <-ch:
+      fmt.Fprintf(w, "data: %s\n\n", bs)
+    case <-keepAlive.C:
+      fmt.Fprintf(w, ": keep-alive\n\n")
+    case <-r.Context().Done():
+      return
+    }
+    flusher.Flush()
+  }
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+  "bytes"
+  "encoding/json"
+  "errors"
+  "flag"
+  "fmt"
+  "os"
+  "unicode/utf8"
+
+  "github.com/eiannone/keyboard"
+  "tezos-contests.izibi.com/backend/signing"
+  "tezos-contests.izibi.com/tc-node/keypair"
+)
+
+/* Environment variable holding the passphrase of the team's key file,
+   for daemons; the passphrase is prompted for otherwise. */
+const passphraseEnv = "TC_NODE_PASSPHRASE"
+
+/* Load the team's key pair, asking for its passphrase if it is encrypted. */
+func loadKeyPair(filename string) (*signing.KeyPair, error) {
+  kp, err := keypair.Load(filename, func() ([]byte, error) {
+    return readPassphrase(fmt.Sprintf("Passphrase of %s: ", filename))
+  })
+  if err != nil { return nil, err }
+  return signingKeyPair(kp)
+}
+
+/* Both packages read and write the same key pair format. */
+func signingKeyPair(kp *keypair.KeyPair) (*signing.KeyPair, error) {
+  b, err := json.Marshal(kp)
+  if err != nil { return nil, err }
+  return signing.ReadKeyPair(bytes.NewReader(b))
+}
+
+func readPassphrase(prompt string) ([]byte, error) {
+  if value, ok := os.LookupEnv(passphraseEnv); ok {
+    return []byte(value), nil
+  }
+  notifier.Final("")
+  return promptPassphrase(prompt)
+}
+
+/* Passphrase of a new key file, entered twice. */
+func newPassphrase() ([]byte, error) {
+  if value, ok := os.LookupEnv(passphraseEnv); ok {
+    if value == "" { return nil, fmt.Errorf("%s is empty", passphraseEnv) }
+    return []byte(value), nil
+  }
+  notifier.Final("")
+  fmt.Println("The team's private key will be encrypted with a passphrase.")
+  passphrase, err := promptPassphrase("New passphrase: ")
+  if err != nil { return nil, err }
+  if len(passphrase) == 0 { return nil, errors.New("empty passphrase") }
+  again, err := promptPassphrase("Repeat the passphrase: ")
+  if err != nil { return nil, err }
+  if !bytes.Equal(passphrase, again) { return nil, errors.New("the passphrases differ") }
+  return passphrase, nil
+}
+
+/* Read a line from the keyboard without echoing it.  The prompt goes to
+   stderr, which keeps stdout for the results of commands. */
+func promptPassphrase(prompt string) ([]byte, error) {
+  err := keyboard.Open()
+  if err != nil {
+    return nil, fmt.Errorf("cannot prompt for the passphrase (%v), set %s", err, passphraseEnv)
+  }
+  defer keyboard.Close()
+  fmt.Fprint(os.Stderr, prompt)
+  defer fmt.Fprintln(os.Stderr)
+  var buf []byte
+  for {
+    ch, key, err := keyboard.GetKey()
+    if err != nil { return nil, err }
+    switch key {
+    case keyboard.KeyEnter:
+      return buf, nil
+    case keyboard.KeyEsc, keyboard.KeyCtrlC:
+      return nil, errors.New("cancelled")
+    case keyboard.KeyBackspace, keyboard.KeyBackspace2:
+      if len(buf) != 0 {
+        _, size := utf8.DecodeLastRune(buf)
+        buf = buf[:len(buf) - size]
+      }
+    case keyboard.KeySpace:
+      buf = append(buf, ' ')
+    default:
+      if ch != 0 {
+        buf = append(buf, string(ch)...)
+      }
+    }
+  }
+}
+
+/*
+  tc-node key generate [-file F] [-force]   create the team's key pair
+  tc-node key show [-file F] [-json]        print the public key and fingerprint
+  tc-node key verify [-file F]              check the private key
+  tc-node key export-public [-file F]       print only the public key
+  tc-node key rotate [-file F]              replace the key pair
+  tc-node key migrate [-file F]             encrypt a plain key file
+
+  The file defaults to the signing setting of config.yaml (team.json).
+*/
+func keyCommand(args []string) int {
+  if len(args) == 0 { return usage("key") }
+  action := args[0]
+  fs := flag.NewFlagSet("key " + action, flag.ContinueOnError)
+  file := fs.String("file", "", "use the key pair in `file`")
+  force := fs.Bool("force", false, "replace an existing key pair (generate)")
+  asJson := fs.Bool("json", false, "print the result as JSON (show)")
+  if fs.Parse(args[1:]) != nil || fs.NArg() != 0 { return usage("key") }
+  if startup(outputPlain) != nil { return exitFailure }
+  notifier.Final("")
+  filename := config.KeypairFilename
+  if *file != "" { filename = *file }
+  var err error
+  switch action {
+  case "generate":
+    err = keyGenerate(filename, *force)
+  case "show":
+    err = keyShow(filename, *asJson)
+  case "verify":
+    err = keyVerify(filename)
+  case "export-public":
+    var public string
+    public, err = keypair.ReadPublic(filename)
+    if err == nil { fmt.Println(public) }
+  case "rotate":
+    err = keyRotate(filename)
+  case "migrate":
+    err = migrateKeyFile(filename)
+    if err == nil { notifier.Final(fmt.Sprintf("%s is encrypted", filename)) }
+  default:
+    return usage("key")
+  }
+  if err != nil {
+    notifier.Error(err)
+    return exitFailure
+  }
+  return exitOk
+}
+
+func keyGenerate(filename string, force bool) error {
+  if _, err := os.Stat(filename); err == nil && !force {
+    return fmt.Errorf("%s already exists, use -force to replace it, or rotate", filename)
+  }
+  /* The old key is only replaced once the new one is saved. */
+  tmp := filename + ".new"
+  os.Remove(tmp)
+  kp, err := writeNewKeyPair(tmp)
+  if err != nil { return err }
+  err = os.Rename(tmp, filename)
+  if err != nil { return err }
+  fmt.Print("A new keypair has been saved in ")
+  SuccessFmt.Println(filename)
+  printPublicKey(kp.Public)
+  fmt.Printf("\nProvide this key in the team tab of the web interface, and\n")
+  fmt.Printf("share %s and its passphrase with your teammates\n", filename)
+  return nil
+}
+
+/* Generate a key pair and save it, encrypted, in a new file. */
+func writeNewKeyPair(filename string) (*keypair.KeyPair, error) {
+  kp, err := keypair.New()
+  if err != nil { return nil, err }
+  passphrase, err := newPassphrase()
+  if err != nil { return nil, err }
+  err = kp.WriteEncrypted(filename, passphrase)
+  if err != nil { return nil, err }
+  return kp, nil
+}
+
+func printPublicKey(public string) {
+  fmt.Printf("Your team's public key: \n    ")
+  ImportantFmt.Printf("%s\n", public)
+  if fingerprint, err := keypair.Fingerprint(public); err == nil {
+    fmt.Printf("Fingerprint: %s\n", fingerprint)
+  }
+}
+
+func keyShow(filename string, asJson bool) error {
+  public, err := keypair.ReadPublic(filename)
+  if err != nil { return err }
+  fingerprint, err := keypair.Fingerprint(public)
+  if err != nil { return fmt.Errorf("bad public key: %v", err) }
+  encrypted, err := keypair.IsEncrypted(filename)
+  if err != nil { return err }
+  info, err := os.Stat(filename)
+  if err != nil { return err }
+  if asJson {
+    printJson(struct {
+      File string `json:"file"`
+      Public string `json:"public"`
+      Fingerprint string `json:"fingerprint"`
+      Encrypted bool `json:"encrypted"`
+    }{filename, public, fingerprint, encrypted})
+    return nil
+  }
+  fmt.Printf("File:        %s\n", filename)
+  fmt.Printf("Public key:  %s\n", public)
+  fmt.Printf("Fingerprint: %s\n", fingerprint)
+  if encrypted {
+    fmt.Printf("Encrypted:   yes\n")
+  } else {
+    WarningFmt.Printf("Encrypted:   no, use \"tc-node key migrate\"\n")
+  }
+  if info.Mode().Perm() & 0077 != 0 {
+    WarningFmt.Printf("Mode:        %v, readable by others\n", info.Mode().Perm())
+  }
+  return nil
+}
+
+func keyVerify(filename string) error {
+  kp, err := keypair.Load(filename, func() ([]byte, error) {
+    return readPassphrase(fmt.Sprintf("Passphrase of %s: ", filename))
+  })
+  if err != nil { return err }
+  err = kp.Check()
+  if err != nil { return fmt.Errorf("%s: %v", filename, err) }
+  _, err = signingKeyPair(kp)
+  if err != nil { return fmt.Errorf("%s: %v", filename, err) }
+  fingerprint, _ := keypair.Fingerprint(kp.Public)
+  SuccessFmt.Printf("%s: the private key matches the public key (%s)\n", filename, fingerprint)
+  return nil
+}
+
+/* Replace the key pair by a new one.  The old file is kept, as
+   FILE.previous, until the new key is registered. */
+func keyRotate(filename string) error {
+  oldPublic, err := keypair.ReadPublic(filename)
+  if err != nil { return err }
+  previous := filename + ".previous"
+  if _, err := os.Stat(previous); err == nil {
+    return fmt.Errorf("%s exists: finish the previous rotation and remove it first", previous)
+  }
+  tmp := filename + ".new"
+  os.Remove(tmp)
+  kp, err := writeNewKeyPair(tmp)
+  if err != nil { return err }
+  err = os.Rename(filename, previous)
+  if err != nil { return err }
+  err = os.Rename(tmp, filename)
+  if err != nil {
+    os.Rename(previous, filename)
+    return err
+  }
+  oldFingerprint, _ := keypair.Fingerprint(oldPublic)
+  fmt.Print("A new keypair has been saved in ")
+  SuccessFmt.Println(filename)
+  fmt.Printf("The previous keypair (%s) was moved to %s\n\n", oldFingerprint, previous)
+  printPublicKey(kp.Public)
+  fmt.Printf("\nTo complete the rotation:\n")
+  fmt.Printf("  1. replace the public key in the team tab of the web interface;\n")
+  fmt.Printf("  2. share %s and its passphrase with your teammates;\n", filename)
+  fmt.Printf("  3. restart the running tc-node sessions, which still use the old key;\n")
+  fmt.Printf("  4. delete %s once the new key is accepted.\n", previous)
+  return nil
+}
+
+/* Encrypt a plain key file in place. */
+func migrateKeyFile(filename string) error {
+  encrypted, err := keypair.IsEncrypted(filename)
+  if err != nil { return err }
+  if encrypted {
+    /* Only tighten the permissions of files written before 0600. */
+    return os.Chmod(filename, 0600)
+  }
+  kp, err := keypair.Load(filename, nil)
+  if err != nil { return err }
+  passphrase, err := newPassphrase()
+  if err != nil { return err }
+  /* Replace the file atomically, so that the key is never lost. */
+  tmp := filename + ".new"
+  os.Remove(tmp)
+  err = kp.WriteEncrypted(tmp, passphrase)
+  if err != nil { return err }
+  return os.Rename(tmp, filename)
+}
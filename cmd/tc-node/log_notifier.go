@@ -0,0 +1,129 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "io"
+  "sort"
+  "strconv"
+  "sync"
+  "time"
+  "tezos-contests.izibi.com/tc-node/api"
+  "tezos-contests.izibi.com/tc-node/client"
+  "tezos-contests.izibi.com/tc-node/notify"
+)
+
+/* Notifier writing one record per line, without colors or cursor
+   movements: logfmt ("text") in daemon mode, JSON lines for log files. */
+type LogNotifier struct {
+  out io.Writer
+  json bool
+  level notify.Level
+  mutex sync.Mutex
+}
+
+func NewLogNotifier(out io.Writer, format string, level notify.Level) *LogNotifier {
+  return &LogNotifier{out: out, json: format == "json", level: level}
+}
+
+func (n *LogNotifier) Log(level notify.Level, msg string, fields notify.Fields) {
+  if level < n.level { return }
+  n.mutex.Lock()
+  defer n.mutex.Unlock()
+  now := time.Now()
+  if n.json {
+    record := notify.Merge(fields, notify.Fields{
+      "time": now.Format(time.RFC3339Nano),
+      "level": level.String(),
+      "msg": msg,
+    })
+    for k, v := range record {
+      if d, ok := v.(time.Duration); ok { record[k] = d.Seconds() }
+      if e, ok := v.(error); ok { record[k] = e.Error() }
+    }
+    bs, err := json.Marshal(record)
+    if err != nil { return }
+    n.out.Write(append(bs, '\n'))
+    return
+  }
+  line := fmt.Sprintf("time=%s level=%s msg=%s",
+    now.Format(time.RFC3339), level, strconv.Quote(msg))
+  var keys []string
+  for k := range fields {
+    keys = append(keys, k)
+  }
+  sort.Strings(keys)
+  for _, k := range keys {
+    line += fmt.Sprintf(" %s=%s", k, strconv.Quote(fmt.Sprint(fields[k])))
+  }
+  fmt.Fprintln(n.out, line)
+}
+
+func (n *LogNotifier) Partial(msg string) {
+  n.Log(notify.Debug, msg, nil)
+}
+
+func (n *LogNotifier) Partialf(format string, a ...interface{}) {
+  n.Partial(fmt.Sprintf(format, a...))
+}
+
+func (n *LogNotifier) Final(msg string) {
+  if msg == "" { return }
+  n.Log(notify.Info, msg, nil)
+}
+
+func (n *LogNotifier) Finalf(format string, a ...interface{}) {
+  n.Final(fmt.Sprintf(format, a...))
+}
+
+func (n *LogNotifier) Warning(msg string) {
+  n.Log(notify.Warning, msg, nil)
+}
+
+func (n *LogNotifier) Warningf(format string, a ...interface{}) {
+  n.Warning(fmt.Sprintf(format, a...))
+}
+
+func (n *LogNotifier) Error(err error) {
+  if apiErr, ok := api.AsApiError(err); ok {
+    n.Log(notify.Error, apiErr.Message, notify.Fields{"details": apiErr.Details})
+  } else {
+    n.Log(notify.Error, err.Error(), nil)
+  }
+}
+
+/* Notifier sending every message to several renderers, used to log to a
+   file in addition to the terminal. */
+type TeeNotifier []client.Notifier
+
+func (t TeeNotifier) Log(level notify.Level, msg string, fields notify.Fields) {
+  for _, n := range t { n.Log(level, msg, fields) }
+}
+
+func (t TeeNotifier) Partial(msg string) {
+  for _, n := range t { n.Partial(msg) }
+}
+
+func (t TeeNotifier) Partialf(format string, a ...interface{}) {
+  t.Partial(fmt.Sprintf(format, a...))
+}
+
+func (t TeeNotifier) Final(msg string) {
+  for _, n := range t { n.Final(msg) }
+}
+
+func (t TeeNotifier) Finalf(format string, a ...interface{}) {
+  t.Final(fmt.Sprintf(format, a...))
+}
+
+func (t TeeNotifier) Warning(msg string) {
+  for _, n := range t { n.Warning(msg) }
+}
+
+func (t TeeNotifier) Warningf(format string, a ...interface{}) {
+  t.Warning(fmt.Sprintf(format, a...))
+}
+
+func (t TeeNotifier) Error(err error) {
+  for _, n := range t { n.Error(err) }
+}
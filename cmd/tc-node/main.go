@@ -4,198 +4,125 @@ package main
 import (
   "flag"
   "fmt"
-  "io/ioutil"
   "os"
-  "path/filepath"
   "time"
 
   "gopkg.in/yaml.v2"
   "github.com/eiannone/keyboard"
-  "tezos-contests.izibi.com/backend/signing"
   "tezos-contests.izibi.com/tc-node/api"
   "tezos-contests.izibi.com/tc-node/block_store"
   "tezos-contests.izibi.com/tc-node/client"
+  "tezos-contests.izibi.com/tc-node/notify"
 )
 
 type Config struct {
   BaseUrl string `yaml:"base_url"`
   ApiBaseUrl string `yaml:"api_base"`
   StoreBaseUrl string `yaml:"store_base"`
+  /* Root of the relative paths below, and of the game's files
+     (game.json, commands.log); defaults to the directory of config.yaml. */
+  Workspace string `yaml:"workspace"`
   StoreCacheDir string `yaml:"store_dir"`
+  HistoryDir string `yaml:"history_dir"`
   ApiKey string `yaml:"api_key"`
   Task string `yaml:"task"`
   KeypairFilename string `yaml:"signing"`
   WatchGameUrl string `yaml:"watch_game_url"`
   NewGameParams map[string]interface{} `yaml:"new_game_params"`
+  ProtocolIntf string `yaml:"protocol_mli"`
+  ProtocolImpl string `yaml:"protocol_ml"`
+  ShowMap bool `yaml:"show_map"`
+  AutoClose client.AutoCloseConfig `yaml:"auto_close"`
+  ClockSyncInterval time.Duration `yaml:"clock_sync_interval"`
+  ClockDriftThreshold time.Duration `yaml:"clock_drift_threshold"`
+  Control ControlConfig `yaml:"control"`
+  LogFile string `yaml:"log_file"`
+  LogFormat string `yaml:"log_format"`
+  LogLevel string `yaml:"log_level"`
+  BotStderr client.StderrConfig `yaml:"bot_stderr"`
   Bots []client.BotConfig `yaml:"bots"`
-  LastRoundCommandsSent uint64
-  Latency time.Duration
-  TimeDelta time.Duration
+  Games []client.GameConfig `yaml:"games"` /* for "tc-node multi" */
+  Profile string `yaml:"profile"` /* profile applied by default */
+  Profiles map[string]yaml.MapSlice `yaml:"profiles"`
+  LastRoundCommandsSent uint64 `yaml:"-"`
+  Latency time.Duration `yaml:"-"`
+  TimeDelta time.Duration `yaml:"-"`
 }
 
 var config Config
 var cl client.Client
 var remote *api.Server
 var store *block_store.Store
-var notifier = &Notifier{}
+var notifier client.Notifier = &Notifier{}
+
+var autoCloseFlag = flag.Bool("auto-close", false,
+  "start with the automatic end of round enabled")
+var closeMarginFlag = flag.Duration("close-margin", 0,
+  "delay after the round deadline before closing the round")
+var logFileFlag = flag.String("log-file", "",
+  "also write messages to `file`")
+var plainFlag = flag.Bool("plain", false,
+  "do not use the full-screen interface")
+var controlFlag = flag.String("control", "",
+  "serve the control API on `address` (host:port or unix:PATH)")
 
 func main() {
-
-  var err error
+  flag.Usage = printUsage
   flag.Parse()
-  cmd := flag.Args()
-
-  /* Load the configuration file. */
-  notifier.Partial("Loading config.yaml")
-  err = Configure()
-  if err != nil { panic(err) }
-
-  /* Load the team's key pair */
-  notifier.Partial("Loading the team's keypair")
-  var teamKeyPair *signing.KeyPair
-  teamKeyPair, err = loadKeyPair(config.KeypairFilename)
-  if err != nil {
-    teamKeyPair, err = generateKeypair()
-    if err != nil {
-      DangerFmt.Printf("failed to generate keypair: %v\n", err)
-      os.Exit(0)
-    }
-    notifier.Final("")
-    fmt.Print("A new keypair has been saved in ")
-    SuccessFmt.Println(config.KeypairFilename)
-    fmt.Printf("Your team's public key: \n    ")
-    ImportantFmt.Printf("%s\n\n", teamKeyPair.Public)
-    fmt.Printf("\nProvide this key in the team tab of the web interface, and\n")
-    fmt.Printf("share your team.json file with your teammates\n")
-    /* Exit because the new key must be associated with a team in the
-       contest's web interface before we can proceed (will not be able
-       to connect the event stream until the team key is recognized). */
-    os.Exit(0)
+  args := flag.Args()
+  name := "run"
+  if len(args) != 0 {
+    name, args = args[0], args[1:]
   }
-  notifier.Final(fmt.Sprintf("Team key: %s", teamKeyPair.Public))
-
-  /* Connect to the API, set up the store, and initialize the game client. */
-  remote = api.New(config.ApiBaseUrl, config.ApiKey, teamKeyPair)
-  store = block_store.New(config.StoreBaseUrl, config.StoreCacheDir)
-  cl = client.New(notifier, config.Task, remote, store, teamKeyPair, config.Bots)
-
-  /* Check the local time. */
-  notifier.Partial("Checking the local time")
-  err = checkTime()
-  if err != nil {
-    notifier.Error(err)
-    os.Exit(0)
-  }
-
-  notifier.Partial("Connecting to the event stream")
-  var ech <-chan interface{}
-  ech, err = cl.Connect()
-  if err != nil {
-    notifier.Error(err)
-    DangerFmt.Printf("\nFailed to connect to the event stream.\n\n")
-    fmt.Printf("Did you link your public key (above) to your team?\n");
-    os.Exit(0)
+  sc, ok := findCommand(name)
+  if !ok {
+    DangerFmt.Printf("Unknown command %q\n\n", name)
+    printUsage()
+    os.Exit(exitUsage)
   }
-
-  if len(cmd) == 0 {
-    /* "tc-node" reloads the current game. */
-    err = cl.LoadGame()
-    if err != nil {
-      notifier.Error(err)
-      fmt.Printf("Use the new or join commands to recover.\n");
-      os.Exit(0)
-    }
-    notifier.Final("Game loaded")
-  } else {
-    switch cmd[0] {
-    case "new":
-      /* "tc-node new" creates a new game */
-      err = cl.NewGame(config.NewGameParams)
-      if err != nil {
-        notifier.Error(err)
-        os.Exit(0)
-      }
-      notifier.Final("Game created")
-      break
-    case "join":
-      /* "tc-node join GAME_KEY" joins the specified game */
-      if len(cmd) < 2 {
-        DangerFmt.Print("\nUsage: join GAME_KEY\n")
-        os.Exit(0)
-      }
-      err = cl.JoinGame(cmd[1])
-      if err != nil {
-        notifier.Error(err)
-        os.Exit(0)
-      }
-      notifier.Final("Game joined")
-    default:
-      DangerFmt.Print("\nwat.\n")
-      os.Exit(0)
-    }
-  }
-  if cl.Game() != nil {
-    fmt.Printf("Game key: ")
-    GameKeyFmt.Println(cl.Game().Key)
-    InteractiveLoop(ech)
-  }
-  os.Exit(0)
+  os.Exit(sc.Run(args))
 }
 
-func Configure() error {
-  var err error
-  var configFile []byte
-  configFile, err = ioutil.ReadFile("config.yaml")
-  if err != nil { return err }
-  err = yaml.Unmarshal(configFile, &config)
+/* Apply the log level to the daemon's output, and add the log file. */
+func setupLogging() error {
+  level, err := notify.ParseLevel(config.LogLevel)
   if err != nil { return err }
-  if config.ApiBaseUrl == "" {
-    config.ApiBaseUrl = config.BaseUrl + "/backend"
+  if ln, ok := notifier.(*LogNotifier); ok {
+    ln.level = level
   }
-  if config.StoreBaseUrl == "" {
-    config.StoreBaseUrl = config.BaseUrl + "/backend/Blocks"
-  }
-  if config.WatchGameUrl == "" {
-    config.WatchGameUrl = config.BaseUrl + "/games"
-  }
-  if config.StoreCacheDir == "" {
-    config.StoreCacheDir = "store"
-  }
-  if config.KeypairFilename == "" {
-    config.KeypairFilename = "team.json"
-  }
-  config.StoreCacheDir, err = filepath.Abs(config.StoreCacheDir)
+  if config.LogFile == "" { return nil }
+  file, err := os.OpenFile(config.LogFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
   if err != nil { return err }
+  notifier = TeeNotifier{notifier, NewLogNotifier(file, config.LogFormat, level)}
   return nil
 }
 
 func InteractiveLoop(ech <-chan interface{}) {
   kch := keyboardChannel()
-  wch, ich := cl.Worker()
+  session := NewSession()
+  session.showMap = config.ShowMap
   defer keyboard.Close()
-  var ticker *time.Ticker
-  var tch <-chan time.Time
+  var redraw <-chan time.Time
+  if tui != nil {
+    tui.Start()
+    defer tui.Stop()
+    ticker := time.NewTicker(tuiRefreshInterval)
+    defer ticker.Stop()
+    redraw = ticker.C
+  }
+  cch := watchFile(configPath())
 
-  wch<- client.AlwaysSendCommands()
+  session.wch<- client.AlwaysSendCommands()
   for {
     select {
-      /* TODO: We get a block event when a new block has been downloaded;
-         we should test whether the block is current, and keep waiting if not.
-         We should also bail out on an end-of-game events.
-       */
       case ev := <-ech:
-        switch e := ev.(type) {
-        case client.NewBlockEvent:
-          wch<- client.SyncThenSendCommands()
-        case error:
-          notifier.Error(e)
-        default:
-          fmt.Printf("event %v\n", ev)
-        }
-        // fmt.Printf("TODO: client worker <- send commands (if needed)\n")
-      case <-tch:
-        fmt.Printf("--- automatic end of round ---\n")
-        ich<- client.EndOfRound()
+        if !session.HandleEvent(ev) { return }
+      case <-session.Deadline():
+        session.DeadlineReached()
+      case <-cch:
+        reloadConfig(session)
+      case <-redraw:
+        tui.Draw(session)
       case kp := <-kch:
         switch kp.key {
         case 0:
@@ -203,23 +130,30 @@ func InteractiveLoop(ech <-chan interface{}) {
             case 0:
               return
             case 'p', 'P':
-              ich<- client.Ping()
+              session.ich<- client.Ping()
             case 's':
-              ich<- client.Sync()
+              session.ich<- client.Sync()
             case 'S':
-              ich<- client.SyncThenSendCommands()
+              session.ich<- client.SyncThenSendCommands()
             case 'a':
-              // toggle automatic timed end-of-round
-              if ticker == nil {
-                ticker = time.NewTicker(15 * time.Second)
-                tch = ticker.C
-                fmt.Printf("Automatic play mode enabled\n")
-                fmt.Printf("Rounds will automatically end every 15 seconds\n")
+              // toggle automatic end-of-round
+              if session.AutoCloseEnabled() {
+                session.DisableAutoClose()
               } else {
-                ticker.Stop()
-                ticker = nil
-                tch = nil
-                fmt.Printf("Automatic play mode disabled\n")
+                session.EnableAutoClose()
+              }
+            case 'm':
+              // toggle the map display
+              session.showMap = !session.showMap
+              if session.showMap && cl.Game() != nil {
+                showState(cl.Game().LastBlock, cl.Game().FirstBlock)
+              }
+            case '1', '2', '3', '4', '5', '6', '7', '8', '9':
+              // toggle the n-th bot
+              index := int(kp.ch - '1')
+              if index < len(config.Bots) {
+                id := config.Bots[index].Id
+                session.SetBotEnabled(id, !cl.BotEnabled(id))
               }
             default:
               // fmt.Printf("ch '%c'\n", kp.ch)
@@ -227,46 +161,28 @@ func InteractiveLoop(ech <-chan interface{}) {
         case keyboard.KeyEsc, keyboard.KeyCtrlC:
           return
         case keyboard.KeySpace:
-          ich<- client.EndOfRound()
+          session.ich<- client.EndOfRound()
         case keyboard.KeyEnter:
-          fmt.Println("Enter")
-          ich<- client.AlwaysSendCommands()
+          notifier.Log(notify.Debug, "Enter", nil)
+          session.ich<- client.AlwaysSendCommands()
         default:
-          fmt.Printf("key %v\n", kp.key)
+          notifier.Log(notify.Debug, fmt.Sprintf("key %v", kp.key), nil)
         }
     }
   }
 }
 
-func loadKeyPair (filename string) (*signing.KeyPair, error) {
-  var err error
-  f, err := os.Open(filename)
-  if err != nil {
-    return nil, err
-  }
-  defer f.Close()
-  return signing.ReadKeyPair(f)
-}
-
-func generateKeypair() (*signing.KeyPair, error) {
-  var err error
-  var kp *signing.KeyPair
-  kp, err = signing.NewKeyPair()
-  if err != nil { return nil, err }
-  file, err := os.OpenFile(config.KeypairFilename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
-  if err != nil { return nil, err }
-  defer file.Close()
-  err = kp.Write(file)
-  if err != nil { return nil, err }
-  return kp, nil
-}
-
 func checkTime() error {
   ts, err := cl.GetTimeStats()
   if err != nil { return err }
   // TODO: post results to an API for statistics?
   config.TimeDelta = ts.Delta
   config.Latency = ts.Latency
+  notifier.Final(fmt.Sprintf("Server clock offset %v, latency %v",
+    ts.Delta.Round(time.Millisecond), ts.Latency.Round(time.Millisecond)))
+  /* Use the corrected clock for request timestamps, and keep it in sync. */
+  remote.Now = cl.ServerTime
+  cl.StartClockSync(config.ClockSyncInterval, config.ClockDriftThreshold)
   return nil
 }
 
@@ -0,0 +1,44 @@
+package main
+
+import (
+  "bytes"
+  "io"
+  "os"
+  "tezos-contests.izibi.com/tc-node/api"
+  "tezos-contests.izibi.com/tc-node/render"
+)
+
+/* Draw the state of a block from the store, highlighting our players. */
+func renderState(out io.Writer, hash string, firstBlock string) {
+  var state api.TaskState
+  err := store.ReadState(hash, &state)
+  if err != nil {
+    notifier.Error(err)
+    return
+  }
+  var side int
+  var setup api.SetupBlock
+  if store.ReadBlock(firstBlock, &setup) == nil {
+    side = int(setup.GameParams.MapSide)
+  }
+  r := render.New(out)
+  if cl != nil {
+    r.Ours = make(map[uint32]bool)
+    for _, rank := range cl.BotRanks() {
+      r.Ours[rank] = true
+    }
+  }
+  r.Render(&state, side)
+}
+
+/* Show the state of a block in the map pane of the full-screen UI, or on
+   stdout. */
+func showState(hash string, firstBlock string) {
+  if tui != nil && tui.Active() {
+    var buf bytes.Buffer
+    renderState(&buf, hash, firstBlock)
+    tui.SetMap(buf.String())
+    return
+  }
+  renderState(os.Stdout, hash, firstBlock)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+  "errors"
+  "fmt"
+  "os"
+  "os/signal"
+  "sync"
+  "syscall"
+  "time"
+  "tezos-contests.izibi.com/backend/signing"
+  "tezos-contests.izibi.com/tc-node/client"
+  "tezos-contests.izibi.com/tc-node/history"
+)
+
+/* "tc-node multi" plays all the games listed under "games" in config.yaml
+   at once, without a keyboard.  SIGINT and SIGTERM stop all games once
+   their workers are idle. */
+func MultiLoop(teamKeyPair *signing.KeyPair) {
+  if len(config.Games) == 0 {
+    notifier.Error(errors.New("no games are listed in config.yaml"))
+    return
+  }
+  manager := client.NewManager(notifier, config.Task, remote, config.StoreBaseUrl,
+    config.StoreCacheDir, history.New(config.HistoryDir), workspace(), teamKeyPair)
+  stop := make(chan struct{})
+  var wg sync.WaitGroup
+  for _, game := range config.Games {
+    notifier.Partial(fmt.Sprintf("Starting game %s", game.Name))
+    gcl, ech, err := manager.Add(game)
+    if err != nil {
+      notifier.Error(fmt.Errorf("game %s: %v", game.Name, err))
+      continue
+    }
+    _, err = gcl.GetTimeStats()
+    if err != nil { notifier.Error(err) }
+    notifier.Final(fmt.Sprintf("Game %s: %s", game.Name, gcl.Game().Key))
+    wg.Add(1)
+    go func(name string) {
+      defer wg.Done()
+      playGame(name, gcl, ech, stop)
+    }(game.Name)
+  }
+
+  done := make(chan struct{})
+  go func() {
+    wg.Wait()
+    close(done)
+  }()
+  sigs := make(chan os.Signal, 1)
+  signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+  defer signal.Stop(sigs)
+  select {
+  case <-done:
+  case sig := <-sigs:
+    notifier.Final("Shutting down (" + sig.String() + ")")
+    close(stop)
+    <-done
+  }
+}
+
+/* Drive the worker of one of the games, from its events and a timer. */
+func playGame(name string, gcl client.Client, ech <-chan interface{}, stop <-chan struct{}) {
+  /* Leave the shared event stream to the other games. */
+  defer gcl.Close()
+  wch, _ := gcl.Worker()
+  ticker := time.NewTicker(daemonSyncInterval)
+  defer ticker.Stop()
+  wch<- client.AlwaysSendCommands()
+  for {
+    select {
+    case ev := <-ech:
+      switch e := ev.(type) {
+      case client.NewBlockEvent:
+        gcl.RequestSync()
+      case client.EndOfGameEvent:
+        cmd, done := client.EndOfGame(e.Reason).WithResult()
+        wch<- cmd
+        <-done
+        notifier.Final(fmt.Sprintf("Game %s has ended", name))
+        return
+      case error:
+        notifier.Error(e)
+      }
+    case <-ticker.C:
+      gcl.RequestSync()
+    case <-stop:
+      cmd, done := client.Noop().WithResult()
+      select {
+      case wch<- cmd:
+        select {
+        case <-done:
+        case <-time.After(shutdownTimeout):
+          notifier.Warning(fmt.Sprintf("Worker of game %s did not finish in time", name))
+        }
+      case <-time.After(shutdownTimeout):
+        notifier.Warning(fmt.Sprintf("Worker of game %s did not finish in time", name))
+      }
+      return
+    }
+  }
+}
@@ -2,9 +2,12 @@
 package main
 
 import (
+  "errors"
   "fmt"
   "github.com/fatih/color"
   "github.com/k0kubun/go-ansi"
+  "tezos-contests.izibi.com/tc-node/api"
+  "tezos-contests.izibi.com/tc-node/notify"
 )
 
 type Notifier struct {
@@ -12,6 +15,21 @@ type Notifier struct {
   errorShown bool
 }
 
+/* Render a structured message like the plain methods do; the fields are
+   only kept by log files. */
+func (n *Notifier) Log(level notify.Level, msg string, fields notify.Fields) {
+  switch level {
+  case notify.Debug:
+    n.Partial(msg)
+  case notify.Info:
+    n.Final(msg)
+  case notify.Warning:
+    n.Warning(msg)
+  default:
+    n.Error(errors.New(msg))
+  }
+}
+
 func (n *Notifier) Partial(msg string) {
   ansi.EraseInLine(1)
   ansi.CursorHorizontalAbsolute(0)
@@ -28,6 +46,8 @@ func (n *Notifier) Final(msg string) {
     ansi.EraseInLine(1)
     ansi.CursorHorizontalAbsolute(0)
     fmt.Println(msg)
+  } else if msg != "" {
+    fmt.Println(msg)
   }
   n.partial = false
 }
@@ -40,8 +60,8 @@ func (n *Notifier) Warning(msg string) {
   if n.partial {
     ansi.EraseInLine(1)
     ansi.CursorHorizontalAbsolute(0)
-    WarningFmt.Println(msg)
   }
+  WarningFmt.Println(msg)
   n.partial = false
 }
 
@@ -54,12 +74,10 @@ func (n *Notifier) Error(err error) {
     DangerFmt.Println(" failed")
     n.partial = false
   }
-  if err.Error() == "API error" {
-    if remote.LastError != "" {
-      DangerFmt.Println(remote.LastError)
-    }
-    if remote.LastDetails != "" {
-      fmt.Println(remote.LastDetails)
+  if apiErr, ok := api.AsApiError(err); ok {
+    DangerFmt.Println(apiErr.Message)
+    if apiErr.Details != "" {
+      fmt.Println(apiErr.Details)
     }
   } else {
     DangerFmt.Println(err.Error())
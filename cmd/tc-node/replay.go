@@ -0,0 +1,199 @@
+package main
+
+import (
+  "fmt"
+  "path/filepath"
+  "strconv"
+  "strings"
+  "github.com/eiannone/keyboard"
+  "tezos-contests.izibi.com/tc-node/api"
+  "tezos-contests.izibi.com/tc-node/block_store"
+  "tezos-contests.izibi.com/tc-node/client"
+  "tezos-contests.izibi.com/tc-node/history"
+  "tezos-contests.izibi.com/tc-node/keypair"
+)
+
+/* Step through the current game as kept in the local store.
+     n, right arrow, space   next round
+     p, left arrow           previous round
+     digits then enter       jump to a round
+     b                       run our current bots on the round
+     q, esc                  quit
+   Returns the process exit code. */
+func ReplayCommand(args []string) int {
+  game, err := readGameFile()
+  if err != nil {
+    DangerFmt.Printf("No current game: %v\n", err)
+    return exitFailure
+  }
+  store = block_store.New(config.StoreBaseUrl, config.StoreCacheDir)
+  err = store.Load()
+  if err != nil {
+    DangerFmt.Printf("Failed to load the store: %v\n", err)
+    return exitFailure
+  }
+  r := &replay{game: game, history: history.New(config.HistoryDir)}
+  err = r.loadChain()
+  if err != nil {
+    DangerFmt.Printf("%v\n", err)
+    return exitFailure
+  }
+  if len(r.blocks) == 0 {
+    WarningFmt.Println("No rounds have been played in this game.")
+    return exitOk
+  }
+  if len(args) != 0 {
+    round, err := strconv.ParseUint(args[0], 10, 64)
+    if err != nil {
+      DangerFmt.Printf("bad round number %q\n", args[0])
+      return exitUsage
+    }
+    r.jump(round)
+  }
+
+  fmt.Print("Replay of ")
+  GameKeyFmt.Println(game.Key)
+  NoticeFmt.Println("n/p: next/previous round, NUMBER enter: jump, b: run bots, q: quit")
+  r.show()
+  kch := keyboardChannel()
+  defer keyboard.Close()
+  var number string
+  for kp := range kch {
+    switch {
+    case kp.ch >= '0' && kp.ch <= '9':
+      number += string(kp.ch)
+      fmt.Printf("\rround %s", number)
+      continue
+    case kp.key == keyboard.KeyEnter && number != "":
+      round, _ := strconv.ParseUint(number, 10, 64)
+      fmt.Println()
+      r.jump(round)
+      r.show()
+    case kp.ch == 'n' || kp.key == keyboard.KeyArrowRight || kp.key == keyboard.KeySpace:
+      if r.index + 1 < len(r.blocks) {
+        r.index += 1
+        r.show()
+      }
+    case kp.ch == 'p' || kp.key == keyboard.KeyArrowLeft:
+      if r.index > 0 {
+        r.index -= 1
+        r.show()
+      }
+    case kp.ch == 'b':
+      r.runBots()
+    case kp.ch == 'q' || kp.key == keyboard.KeyEsc || kp.key == keyboard.KeyCtrlC:
+      return exitOk
+    }
+    number = ""
+  }
+  return exitOk
+}
+
+type replayBlock struct {
+  hash string
+  parent string
+  round uint64
+}
+
+type replay struct {
+  game *api.GameState
+  history *history.History
+  blocks []replayBlock /* command blocks, in chain order */
+  index int
+}
+
+/* Collect the command blocks from FirstBlock to LastBlock. */
+func (r *replay) loadChain() error {
+  var blocks []replayBlock
+  hash := r.game.LastBlock
+  for hash != "" && hash != r.game.FirstBlock {
+    var block api.CommandBlock
+    err := store.ReadBlock(hash, &block)
+    if err != nil { return err }
+    if block.Commands != nil {
+      blocks = append(blocks, replayBlock{hash, block.Parent, uint64(block.Round)})
+    }
+    hash = block.Parent
+  }
+  for i, j := 0, len(blocks) - 1; i < j; i, j = i + 1, j - 1 {
+    blocks[i], blocks[j] = blocks[j], blocks[i]
+  }
+  r.blocks = blocks
+  return nil
+}
+
+func (r *replay) jump(round uint64) {
+  for i, block := range r.blocks {
+    if block.round <= round { r.index = i }
+  }
+}
+
+func (r *replay) show() {
+  block := r.blocks[r.index]
+  fmt.Printf("\n(%d/%d) ", r.index + 1, len(r.blocks))
+  printRound(block.round, block.hash, r.game.FirstBlock)
+}
+
+/* Run the current bots on the state the round's commands were computed
+   from, and compare with what they submitted then. */
+func (r *replay) runBots() {
+  block := r.blocks[r.index]
+  var parent api.CommandBlock
+  err := store.ReadBlock(block.parent, &parent)
+  if err != nil {
+    notifier.Error(err)
+    return
+  }
+  round := uint64(parent.Round)
+  blockDir := store.BlockDir(block.parent)
+  env := client.CommandEnv{
+    RoundNumber: round,
+    NbCycles: r.game.NbCyclesPerRound,
+    GameKey: r.game.Key,
+    LastBlock: block.parent,
+    BlockDir: blockDir,
+    StatePath: filepath.Join(blockDir, "state.json"),
+  }
+  var previous *api.CommandBlock
+  if parent.Commands != nil {
+    previous = &parent
+    env.PreviousBlockPath = filepath.Join(blockDir, "block.json")
+  }
+  var setup api.SetupBlock
+  if store.ReadBlock(r.game.FirstBlock, &setup) == nil {
+    env.GameParams = &setup.GameParams
+  }
+  if public, err := keypair.ReadPublic(config.KeypairFilename); err == nil {
+    env.TeamKey = public
+  }
+  for i := range config.Bots {
+    bot := &config.Bots[i]
+    rec, err := r.history.Record(r.game.Key, round, bot.Id)
+    if err != nil {
+      WarningFmt.Printf("bot id %d: no record of its player in round %d\n", bot.Id, round)
+      continue
+    }
+    env.BotId = bot.Id
+    env.PlayerNumber = rec.Player
+    env.PreviousCommands = client.PlayerCommands(previous, rec.Player)
+    run, err := client.RunBot(bot, config.Workspace, env, client.StderrConfig{Quiet: true})
+    ImportantFmt.Printf("bot id %d, player %d\n", bot.Id, rec.Player)
+    if err != nil {
+      DangerFmt.Printf("  failed: %v\n", err)
+      continue
+    }
+    submitted := strings.TrimSpace(rec.Stdout)
+    now := strings.TrimSpace(run.Stdout)
+    if !rec.Submitted {
+      fmt.Printf("  submitted: (nothing, %s)\n", rec.Status)
+    } else {
+      fmt.Printf("  submitted: %s\n", strings.Replace(submitted, "\n", " | ", -1))
+    }
+    fmt.Printf("  now:       %s\n", strings.Replace(now, "\n", " | ", -1))
+    if rec.Submitted && submitted == now {
+      SuccessFmt.Println("  same")
+    } else {
+      WarningFmt.Println("  differs")
+    }
+  }
+}
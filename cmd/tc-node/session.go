@@ -0,0 +1,195 @@
+package main
+
+import (
+  "fmt"
+  "time"
+  "tezos-contests.izibi.com/tc-node/client"
+)
+
+/* State shared by the interactive and daemon loops: the worker channels,
+   and the scheduler for the automatic end of round. */
+type Session struct {
+  wch chan<- client.Command
+  ich chan<- client.Command
+  lastRound client.RoundEvent
+  scheduler *client.RoundScheduler
+  tch <-chan time.Time
+  showMap bool /* draw the map after each new round */
+}
+
+func NewSession() *Session {
+  wch, ich := cl.Worker()
+  s := &Session{wch: wch, ich: ich}
+  s.wch<- client.SetStderr(botStderrConfig())
+  if config.AutoClose.Enabled {
+    s.EnableAutoClose()
+  }
+  if config.Control.Listen != "" {
+    err := StartControl(s)
+    if err != nil { notifier.Error(err) }
+  }
+  return s
+}
+
+/* The bots' stderr goes to the event log of the full-screen UI. */
+func botStderrConfig() client.StderrConfig {
+  cfg := config.BotStderr
+  if tui != nil { cfg.Out = tui }
+  return cfg
+}
+
+/* Enable or disable a bot from the next round on. */
+func (s *Session) SetBotEnabled(botId uint32, enabled bool) error {
+  var bot *client.BotConfig
+  for i := range config.Bots {
+    if config.Bots[i].Id == botId { bot = &config.Bots[i] }
+  }
+  if bot == nil { return fmt.Errorf("unknown bot id %d", botId) }
+  cl.SetBotEnabled(botId, enabled)
+  switch {
+  case enabled:
+    notifier.Finalf("Bot id %d enabled", botId)
+  case bot.Fallback != "":
+    notifier.Finalf("Bot id %d disabled, running its fallback command", botId)
+  default:
+    notifier.Finalf("Bot id %d disabled, sending no commands", botId)
+  }
+  return nil
+}
+
+/* Handle an event received from the client.  Returns false if the loop
+   should exit. */
+func (s *Session) HandleEvent(ev interface{}) bool {
+  publishEvent(ev)
+  switch e := ev.(type) {
+  case client.NewBlockEvent:
+    /* Bursts of blocks are coalesced into a single sync. */
+    cl.RequestSync()
+  case client.EndOfGameEvent:
+    return s.endOfGame(e)
+  case client.RoundEvent:
+    if e.Seq > s.lastRound.Seq {
+      newRound := e.Block != s.lastRound.Block
+      s.lastRound = e
+      if s.showMap && newRound && cl.Game() != nil {
+        showState(e.Block, cl.Game().FirstBlock)
+      }
+    }
+    if s.scheduler != nil {
+      s.closeRound(s.scheduler.Update(e))
+      s.tch = s.scheduler.C()
+    }
+  case client.SystemEvent:
+    notifier.Final(fmt.Sprintf("system: %s", e.Payload))
+  case error:
+    notifier.Error(e)
+  default:
+    notifier.Warningf("unexpected event %v", ev)
+  }
+  return true
+}
+
+/* Forward an event to the dashboard. */
+func publishEvent(ev interface{}) {
+  switch e := ev.(type) {
+  case client.NewBlockEvent:
+    hub.Publish("block", e)
+  case client.EndOfGameEvent:
+    hub.Publish("end", e)
+  case client.RoundEvent:
+    hub.Publish("round", e)
+  case client.SystemEvent:
+    hub.Publish("system", e)
+  case error:
+    hub.Publish("error", errorResult(e))
+  }
+}
+
+/* Channel that fires at the round deadline, if automatic play is enabled. */
+func (s *Session) Deadline() <-chan time.Time {
+  return s.tch
+}
+
+func (s *Session) DeadlineReached() {
+  s.tch = nil
+  if s.scheduler != nil && s.scheduler.Expired() {
+    notifier.Final(fmt.Sprintf("--- round %d deadline reached ---", s.lastRound.Round))
+    s.ich<- client.EndOfRound()
+  }
+}
+
+/* End the round if the scheduler says so, telling why. */
+func (s *Session) closeRound(reason client.CloseReason) {
+  switch reason {
+  case client.CloseAllSubmitted:
+    notifier.Final(fmt.Sprintf("--- all players are ready, ending round %d ---", s.lastRound.Round))
+  case client.CloseDeadline:
+    notifier.Final(fmt.Sprintf("--- round %d deadline reached ---", s.lastRound.Round))
+  default:
+    return
+  }
+  s.ich<- client.EndOfRound()
+}
+
+func (s *Session) AutoCloseEnabled() bool {
+  return s.scheduler != nil
+}
+
+func (s *Session) EnableAutoClose() {
+  if s.scheduler != nil {
+    s.scheduler.Stop()
+  }
+  s.scheduler = client.NewRoundScheduler(config.AutoClose)
+  printAutoClose()
+  if s.lastRound.Seq != 0 {
+    s.closeRound(s.scheduler.Update(s.lastRound))
+  }
+  s.tch = s.scheduler.C()
+}
+
+func (s *Session) DisableAutoClose() {
+  if s.scheduler == nil { return }
+  s.scheduler.Stop()
+  s.scheduler = nil
+  s.tch = nil
+  notifier.Final("Automatic play mode disabled")
+}
+
+/* Wait (at most timeout) for the worker to finish its current command. */
+func (s *Session) Drain(timeout time.Duration) bool {
+  cmd, done := client.Noop().WithResult()
+  select {
+  case s.wch<- cmd:
+  case <-time.After(timeout):
+    return false
+  }
+  select {
+  case <-done:
+    return true
+  case <-time.After(timeout):
+    return false
+  }
+}
+
+func printAutoClose() {
+  notifier.Final("Automatic play mode enabled")
+  if config.AutoClose.AllSubmitted {
+    notifier.Final("Rounds will end when all players have sent their commands")
+  }
+  if config.AutoClose.Deadline {
+    msg := "Rounds will end at the round deadline"
+    if config.AutoClose.Margin != 0 {
+      msg += fmt.Sprintf(" (+%v)", config.AutoClose.Margin)
+    }
+    notifier.Final(msg)
+  }
+}
+
+/* Wait for the final game state.  The loop always exits at the end of
+   the game. */
+func (s *Session) endOfGame(ev client.EndOfGameEvent) bool {
+  cmd, done := client.EndOfGame(ev.Reason).WithResult()
+  s.wch<- cmd
+  <-done
+  return false
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+  "errors"
+  "fmt"
+  "os"
+  "regexp"
+  "strings"
+  "sync"
+  "time"
+  "unicode/utf8"
+  "github.com/mattn/go-isatty"
+  "tezos-contests.izibi.com/tc-node/api"
+  "tezos-contests.izibi.com/tc-node/client"
+  "tezos-contests.izibi.com/tc-node/notify"
+)
+
+/* The full-screen UI of the interactive loop, or nil if stdout is not a
+   terminal (or -plain was given). */
+var tui *TUI
+
+/* Interval between redraws of the full-screen UI. */
+const tuiRefreshInterval = 200 * time.Millisecond
+
+/* Number of event log lines kept (and printed when the UI stops). */
+const tuiLogSize = 200
+
+/* Full-screen terminal UI, with panes for the game status, the bots, the
+   map, the event log and the keys.  It is also the Notifier: until Start
+   is called, and after Stop, messages go to the plain terminal notifier;
+   in between they go to the event log pane. */
+type TUI struct {
+  plain *Notifier
+  mutex sync.Mutex
+  active bool
+  log []string
+  partial string
+  mapText string
+}
+
+func NewTUI() *TUI {
+  return &TUI{plain: &Notifier{}}
+}
+
+/* Whether the full-screen UI can be used on stdout. */
+func isTerminal() bool {
+  return isatty.IsTerminal(os.Stdout.Fd()) || isatty.IsCygwinTerminal(os.Stdout.Fd())
+}
+
+func (t *TUI) Active() bool {
+  t.mutex.Lock()
+  defer t.mutex.Unlock()
+  return t.active
+}
+
+/* Switch to the alternate screen. */
+func (t *TUI) Start() {
+  t.mutex.Lock()
+  defer t.mutex.Unlock()
+  t.active = true
+  fmt.Print("\x1b[?1049h\x1b[?25l")
+}
+
+/* Restore the screen, and print the end of the event log on it. */
+func (t *TUI) Stop() {
+  t.mutex.Lock()
+  defer t.mutex.Unlock()
+  if !t.active { return }
+  t.active = false
+  fmt.Print("\x1b[?25h\x1b[?1049l")
+  start := len(t.log) - 20
+  if start < 0 { start = 0 }
+  for _, line := range t.log[start:] {
+    fmt.Println(line)
+  }
+}
+
+func (t *TUI) SetMap(text string) {
+  t.mutex.Lock()
+  t.mapText = text
+  t.mutex.Unlock()
+}
+
+func (t *TUI) addLine(line string) {
+  for _, l := range strings.Split(strings.TrimRight(line, "\n"), "\n") {
+    t.log = append(t.log, NoticeFmt.Sprint(time.Now().Format("15:04:05")) + " " + l)
+  }
+  if len(t.log) > tuiLogSize {
+    t.log = t.log[len(t.log) - tuiLogSize:]
+  }
+  t.partial = ""
+}
+
+/* Bots' stderr lines. */
+func (t *TUI) Write(p []byte) (int, error) {
+  t.mutex.Lock()
+  defer t.mutex.Unlock()
+  if !t.active { return os.Stderr.Write(p) }
+  t.addLine(string(p))
+  return len(p), nil
+}
+
+func (t *TUI) Log(level notify.Level, msg string, fields notify.Fields) {
+  switch level {
+  case notify.Debug:
+    t.Partial(msg)
+  case notify.Info:
+    t.Final(msg)
+  case notify.Warning:
+    t.Warning(msg)
+  default:
+    t.Error(errors.New(msg))
+  }
+}
+
+func (t *TUI) Partial(msg string) {
+  t.mutex.Lock()
+  defer t.mutex.Unlock()
+  if !t.active { t.plain.Partial(msg); return }
+  t.partial = msg
+}
+
+func (t *TUI) Partialf(format string, a ...interface{}) {
+  t.Partial(fmt.Sprintf(format, a...))
+}
+
+func (t *TUI) Final(msg string) {
+  t.mutex.Lock()
+  defer t.mutex.Unlock()
+  if !t.active { t.plain.Final(msg); return }
+  if msg == "" {
+    t.partial = ""
+    return
+  }
+  t.addLine(msg)
+}
+
+func (t *TUI) Finalf(format string, a ...interface{}) {
+  t.Final(fmt.Sprintf(format, a...))
+}
+
+func (t *TUI) Warning(msg string) {
+  t.mutex.Lock()
+  defer t.mutex.Unlock()
+  if !t.active { t.plain.Warning(msg); return }
+  t.addLine(WarningFmt.Sprint(msg))
+}
+
+func (t *TUI) Warningf(format string, a ...interface{}) {
+  t.Warning(fmt.Sprintf(format, a...))
+}
+
+func (t *TUI) Error(err error) {
+  t.mutex.Lock()
+  defer t.mutex.Unlock()
+  if !t.active { t.plain.Error(err); return }
+  if apiErr, ok := api.AsApiError(err); ok {
+    t.addLine(DangerFmt.Sprint(apiErr.Message))
+    if apiErr.Details != "" {
+      t.addLine(apiErr.Details)
+    }
+  } else {
+    t.addLine(DangerFmt.Sprint(err.Error()))
+  }
+}
+
+/* Redraw the whole screen. */
+func (t *TUI) Draw(session *Session) {
+  width, height, err := terminalSize()
+  if err != nil || width <= 0 || height <= 0 {
+    width, height = 80, 24
+  }
+  var top []string
+  /* The worker runs concurrently: draw what it last published. */
+  snap := cl.Snapshot()
+  top = append(top, t.statusLines(session, snap)...)
+  top = append(top, paneTitle("Bots", width))
+  top = append(top, botLines(snap)...)
+
+  t.mutex.Lock()
+  defer t.mutex.Unlock()
+  if !t.active { return }
+  if t.mapText != "" && session.showMap {
+    top = append(top, paneTitle("Map", width))
+    top = append(top, strings.Split(strings.TrimRight(t.mapText, "\n"), "\n")...)
+  }
+  top = append(top, paneTitle("Events", width))
+  bottom := []string{
+    NoticeFmt.Sprint(t.partial),
+    paneTitle("Keys", width),
+    "p ping  s sync  S sync+send  enter send  space end round  a auto-close",
+    "m map  1-9 enable/disable bot  esc quit",
+  }
+  /* The event log gets the remaining lines, showing the latest events. */
+  logHeight := height - len(top) - len(bottom)
+  if logHeight < 0 { logHeight = 0 }
+  start := len(t.log) - logHeight
+  if start < 0 { start = 0 }
+  lines := append(top, t.log[start:]...)
+  for i := len(t.log) - start; i < logHeight; i++ {
+    lines = append(lines, "")
+  }
+  lines = append(lines, bottom...)
+  if len(lines) > height { lines = lines[:height] }
+
+  var buf strings.Builder
+  buf.WriteString("\x1b[H")
+  for i, line := range lines {
+    buf.WriteString(clip(line, width))
+    buf.WriteString("\x1b[K")
+    if i + 1 < len(lines) { buf.WriteString("\r\n") }
+  }
+  buf.WriteString("\x1b[J")
+  fmt.Print(buf.String())
+}
+
+func (t *TUI) statusLines(session *Session, snap client.Snapshot) []string {
+  game := snap.Game
+  if game == nil {
+    return []string{ImportantFmt.Sprint("tc-node") + "  no game"}
+  }
+  round := session.lastRound
+  line1 := fmt.Sprintf("%s  game %s  %s  round %d",
+    ImportantFmt.Sprint("tc-node"), GameKeyFmt.Sprint(game.Key),
+    cl.Phase().String(), round.Round)
+  if round.NbPlayers != 0 {
+    line1 += fmt.Sprintf("  %d/%d submitted", round.NbSubmitted, round.NbPlayers)
+  }
+  var line2 string
+  if deadline := snap.Deadline; !deadline.IsZero() {
+    left := time.Until(deadline)
+    if left < 0 {
+      line2 = WarningFmt.Sprintf("deadline passed %s ago", (-left).Round(100 * time.Millisecond))
+    } else {
+      line2 = fmt.Sprintf("deadline in %s", left.Round(100 * time.Millisecond))
+    }
+  } else {
+    line2 = "no deadline"
+  }
+  if session.AutoCloseEnabled() {
+    line2 += "  auto-close " + SuccessFmt.Sprint("on")
+  } else {
+    line2 += "  auto-close off"
+  }
+  return []string{line1, line2}
+}
+
+func botLines(snap client.Snapshot) []string {
+  var results = make(map[uint32]client.BotFeedback)
+  for _, fb := range cl.BotResults() {
+    results[fb.Bot.Id] = fb
+  }
+  ranks := snap.BotRanks
+  lines := []string{fmt.Sprintf(" %-4s %-6s %-8s %-9s %6s %9s  %s",
+    "id", "player", "enabled", "status", "round", "duration", "error")}
+  for i, bot := range snap.Bots {
+    player := "-"
+    if i < len(ranks) { player = fmt.Sprintf("%d", ranks[i]) }
+    enabled := fmt.Sprintf("%-8s", "yes")
+    if !cl.BotEnabled(bot.Id) { enabled = WarningFmt.Sprintf("%-8s", "no") }
+    status, round, duration, errMsg := "-", "-", "-", ""
+    if fb, ok := results[bot.Id]; ok {
+      status = fb.Status
+      round = fmt.Sprintf("%d", fb.Round)
+      duration = fb.Duration.Round(time.Millisecond).String()
+      if fb.Err != nil { errMsg = fb.Err.Error() }
+    }
+    statusText := fmt.Sprintf("%-9s", status)
+    switch status {
+    case client.BotReady:
+      statusText = SuccessFmt.Sprint(statusText)
+    case client.BotFailed, client.BotTooSlow, client.BotRejected:
+      statusText = DangerFmt.Sprint(statusText)
+    }
+    lines = append(lines, fmt.Sprintf(" %-4d %-6s %s %s %6s %9s  %s",
+      bot.Id, player, enabled, statusText, round, duration, errMsg))
+  }
+  return lines
+}
+
+func paneTitle(title string, width int) string {
+  line := "─── " + title + " "
+  n := width - utf8.RuneCountInString(line)
+  if n > 0 { line += strings.Repeat("─", n) }
+  return NoticeFmt.Sprint(line)
+}
+
+var ansiEscape = regexp.MustCompile("\x1b\\[[0-9;?]*[A-Za-z]")
+
+/* Cut a line to width visible characters, keeping its escape sequences. */
+func clip(line string, width int) string {
+  var buf strings.Builder
+  visible := 0
+  for len(line) > 0 {
+    if loc := ansiEscape.FindStringIndex(line); loc != nil && loc[0] == 0 {
+      buf.WriteString(line[:loc[1]])
+      line = line[loc[1]:]
+      continue
+    }
+    r, size := utf8.DecodeRuneInString(line)
+    line = line[size:]
+    if visible < width {
+      buf.WriteRune(r)
+      visible += 1
+    }
+  }
+  return buf.String()
+}
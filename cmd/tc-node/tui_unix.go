@@ -0,0 +1,14 @@
+// +build !windows
+
+package main
+
+import (
+  "os"
+  "golang.org/x/sys/unix"
+)
+
+func terminalSize() (int, int, error) {
+  ws, err := unix.IoctlGetWinsize(int(os.Stdout.Fd()), unix.TIOCGWINSZ)
+  if err != nil { return 0, 0, err }
+  return int(ws.Col), int(ws.Row), nil
+}
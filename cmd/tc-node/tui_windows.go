@@ -0,0 +1,17 @@
+// +build windows
+
+package main
+
+import (
+  "os"
+  "golang.org/x/sys/windows"
+)
+
+func terminalSize() (int, int, error) {
+  var info windows.ConsoleScreenBufferInfo
+  err := windows.GetConsoleScreenBufferInfo(windows.Handle(os.Stdout.Fd()), &info)
+  if err != nil { return 0, 0, err }
+  width := int(info.Window.Right - info.Window.Left) + 1
+  height := int(info.Window.Bottom - info.Window.Top) + 1
+  return width, height, nil
+}
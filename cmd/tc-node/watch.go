@@ -0,0 +1,122 @@
+package main
+
+import (
+  "fmt"
+  "os"
+  "os/signal"
+  "sort"
+  "strings"
+  "syscall"
+  "tezos-contests.izibi.com/tc-node/api"
+  "tezos-contests.izibi.com/tc-node/client"
+)
+
+/* Follow a game without playing: print a summary of each new round, with
+   the commands of each player and the scores. */
+func WatchLoop(ech <-chan interface{}) {
+  wch, _ := cl.Worker()
+  sigs := make(chan os.Signal, 1)
+  signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+  defer signal.Stop(sigs)
+
+  fmt.Print("Watching ")
+  GameKeyFmt.Print(cl.Game().Key)
+  fmt.Print(" -- ")
+  ImportantFmt.Println(watchUrl(cl.Game().Key))
+  lastRound := printNewRounds(0, true)
+  for {
+    select {
+    case ev := <-ech:
+      switch e := ev.(type) {
+      case client.NewBlockEvent:
+        cmd, done := client.Sync().WithResult()
+        wch<- cmd
+        err := <-done
+        if err != nil { continue }
+        lastRound = printNewRounds(lastRound, false)
+      case client.EndOfGameEvent:
+        /* Fetch the final state and blocks before printing the outcome. */
+        cmd, done := client.EndOfGame(e.Reason).WithResult()
+        wch<- cmd
+        err := <-done
+        if err != nil { return }
+        printNewRounds(lastRound, false)
+        return
+      case error:
+        notifier.Error(e)
+      }
+    case <-sigs:
+      return
+    }
+  }
+}
+
+func watchUrl(gameKey string) string {
+  return strings.TrimRight(config.WatchGameUrl, "/") + "/" + gameKey
+}
+
+/* Print the rounds played after round `after` (only the last one if
+   latestOnly), and return the last round printed. */
+func printNewRounds(after uint64, latestOnly bool) uint64 {
+  var blocks []string
+  var rounds []uint64
+  hash := cl.Game().LastBlock
+  for hash != "" && hash != cl.Game().FirstBlock {
+    var block api.CommandBlock
+    err := store.ReadBlock(hash, &block)
+    if err != nil {
+      notifier.Error(err)
+      break
+    }
+    if uint64(block.Round) <= after { break }
+    if block.Commands != nil {
+      blocks = append(blocks, hash)
+      rounds = append(rounds, uint64(block.Round))
+      if latestOnly { break }
+    }
+    hash = block.Parent
+  }
+  for i := len(blocks) - 1; i >= 0; i-- {
+    printRound(rounds[i], blocks[i], cl.Game().FirstBlock)
+  }
+  if len(rounds) == 0 { return after }
+  return rounds[0]
+}
+
+func printRound(round uint64, hash string, firstBlock string) {
+  var block api.CommandBlock
+  err := store.ReadBlock(hash, &block)
+  if err != nil {
+    notifier.Error(err)
+    return
+  }
+  ImportantFmt.Printf("--- Round %d ---", round)
+  NoticeFmt.Printf(" %s\n", hash)
+  for _, line := range roundCommandLines(&block) {
+    fmt.Println(line)
+  }
+  renderState(os.Stdout, hash, firstBlock)
+}
+
+/* One line per player, listing the player's command in each cycle. */
+func roundCommandLines(block *api.CommandBlock) []string {
+  var byRank = make(map[uint32][]string)
+  var ranks []uint32
+  for cycle, commands := range block.Commands {
+    for _, cmd := range commands {
+      if _, ok := byRank[cmd.PlayerRank]; !ok {
+        ranks = append(ranks, cmd.PlayerRank)
+      }
+      for len(byRank[cmd.PlayerRank]) < cycle {
+        byRank[cmd.PlayerRank] = append(byRank[cmd.PlayerRank], "-")
+      }
+      byRank[cmd.PlayerRank] = append(byRank[cmd.PlayerRank], cmd.Command)
+    }
+  }
+  sort.Slice(ranks, func (i, j int) bool { return ranks[i] < ranks[j] })
+  var lines []string
+  for _, rank := range ranks {
+    lines = append(lines, fmt.Sprintf("  player %-3d %s", rank, strings.Join(byRank[rank], " | ")))
+  }
+  return lines
+}
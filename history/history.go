@@ -0,0 +1,175 @@
+/*
+  Keep a record of every bot run, in a directory per game:
+
+    <history dir>/<game key>/<round>/bot-<bot id>-<attempt>.json
+
+  A record holds the bot's inputs and outputs, and the server's response to
+  the commands it produced.  A bot that is run again in the same round
+  (after a rejection, or on a newer block) gets a record per attempt.
+*/
+
+package history
+
+import (
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "sort"
+  "strconv"
+  "time"
+  "github.com/go-errors/errors"
+  "tezos-contests.izibi.com/tc-node/api"
+)
+
+type Record struct {
+  GameKey string `json:"gameKey"`
+  Round uint64 `json:"round"`
+  Block string `json:"block"`
+  BotId uint32 `json:"botId"`
+  Attempt int `json:"attempt"` /* from 1, set by Write */
+  Player uint32 `json:"player"`
+  Command string `json:"command"`
+  Env []string `json:"env"` /* variables set by tc-node */
+  Input string `json:"input"`
+  Stdout string `json:"stdout"`
+  Stderr string `json:"stderr"`
+  ExitStatus string `json:"exitStatus"`
+  StartedAt time.Time `json:"startedAt"`
+  Duration time.Duration `json:"duration"`
+  Status string `json:"status"`
+  Submitted bool `json:"submitted"`
+  Error string `json:"error,omitempty"`
+  ServerError string `json:"serverError,omitempty"`
+  ServerDetails string `json:"serverDetails,omitempty"`
+}
+
+type History struct {
+  Dir string
+}
+
+func New(dir string) *History {
+  return &History{Dir: dir}
+}
+
+/* The directory of a game; the key comes from the server or the command
+   line, and must not lead out of the history. */
+func (h *History) gameDir(gameKey string) (string, error) {
+  err := api.CheckGameKey(gameKey)
+  if err != nil { return "", err }
+  return filepath.Join(h.Dir, gameKey), nil
+}
+
+func (h *History) roundDir(gameKey string, round uint64) (string, error) {
+  dir, err := h.gameDir(gameKey)
+  if err != nil { return "", err }
+  return filepath.Join(dir, strconv.FormatUint(round, 10)), nil
+}
+
+func recordName(botId uint32, attempt int) string {
+  return fmt.Sprintf("bot-%d-%d.json", botId, attempt)
+}
+
+/* Parse the name of a record file. */
+func parseRecordName(name string) (uint32, int, bool) {
+  var botId uint32
+  var attempt int
+  n, err := fmt.Sscanf(name, "bot-%d-%d.json", &botId, &attempt)
+  if err != nil || n != 2 || name != recordName(botId, attempt) { return 0, 0, false }
+  return botId, attempt, true
+}
+
+/* Save a record as the next attempt of its bot in the round. */
+func (h *History) Write(rec *Record) error {
+  dir, err := h.roundDir(rec.GameKey, rec.Round)
+  if err != nil { return err }
+  err = os.MkdirAll(dir, os.ModePerm)
+  if err != nil { return errors.Errorf("failed to create '%s'", dir) }
+  for attempt := 1; ; attempt++ {
+    path := filepath.Join(dir, recordName(rec.BotId, attempt))
+    file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+    if os.IsExist(err) { continue }
+    if err != nil { return errors.Errorf("failed to create '%s'", path) }
+    rec.Attempt = attempt
+    bs, err := json.MarshalIndent(rec, "", "  ")
+    if err == nil { _, err = file.Write(bs) }
+    if cerr := file.Close(); err == nil { err = cerr }
+    if err != nil { return errors.Errorf("failed to write '%s'", path) }
+    return nil
+  }
+}
+
+/* Keys of the games that have a history. */
+func (h *History) Games() ([]string, error) {
+  infos, err := ioutil.ReadDir(h.Dir)
+  if err != nil {
+    if os.IsNotExist(err) { return nil, nil }
+    return nil, err
+  }
+  var keys []string
+  for _, info := range infos {
+    if info.IsDir() { keys = append(keys, info.Name()) }
+  }
+  return keys, nil
+}
+
+/* Rounds of a game that have records, in increasing order. */
+func (h *History) Rounds(gameKey string) ([]uint64, error) {
+  dir, err := h.gameDir(gameKey)
+  if err != nil { return nil, err }
+  infos, err := ioutil.ReadDir(dir)
+  if err != nil {
+    if os.IsNotExist(err) { return nil, nil }
+    return nil, err
+  }
+  var rounds []uint64
+  for _, info := range infos {
+    round, err := strconv.ParseUint(info.Name(), 10, 64)
+    if err == nil && info.IsDir() { rounds = append(rounds, round) }
+  }
+  sort.Slice(rounds, func (i, j int) bool { return rounds[i] < rounds[j] })
+  return rounds, nil
+}
+
+/* Records of a round, ordered by bot id and attempt. */
+func (h *History) Records(gameKey string, round uint64) ([]Record, error) {
+  dir, err := h.roundDir(gameKey, round)
+  if err != nil { return nil, err }
+  infos, err := ioutil.ReadDir(dir)
+  if err != nil {
+    if os.IsNotExist(err) { return nil, nil }
+    return nil, err
+  }
+  var records []Record
+  for _, info := range infos {
+    name := info.Name()
+    if _, _, ok := parseRecordName(name); !ok { continue }
+    path := filepath.Join(dir, name)
+    bs, err := ioutil.ReadFile(path)
+    if err != nil { return nil, errors.Errorf("failed to read '%s'", path) }
+    var rec Record
+    err = json.Unmarshal(bs, &rec)
+    if err != nil { return nil, errors.Errorf("bad record '%s': %s", path, err) }
+    records = append(records, rec)
+  }
+  sort.Slice(records, func (i, j int) bool {
+    if records[i].BotId != records[j].BotId { return records[i].BotId < records[j].BotId }
+    return records[i].Attempt < records[j].Attempt
+  })
+  return records, nil
+}
+
+/* The last attempt of a bot in a round. */
+func (h *History) Record(gameKey string, round uint64, botId uint32) (*Record, error) {
+  records, err := h.Records(gameKey, round)
+  if err != nil { return nil, err }
+  var last *Record
+  for i := range records {
+    if records[i].BotId == botId { last = &records[i] }
+  }
+  if last == nil {
+    return nil, errors.Errorf("no record of bot %d in round %d", botId, round)
+  }
+  return last, nil
+}
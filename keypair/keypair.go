@@ -2,9 +2,13 @@
 package keypair
 
 import (
+  "bytes"
   "crypto/rand"
+  "crypto/sha256"
   "encoding/base64"
   "encoding/json"
+  "errors"
+  "fmt"
   "os"
   "strings"
   "golang.org/x/crypto/ed25519"
@@ -21,6 +25,42 @@ func (s *KeyPair) RawPrivate() ([]byte, error) {
   return base64.StdEncoding.DecodeString(b64)
 }
 
+func (s *KeyPair) RawPublic() ([]byte, error) {
+  b64 := strings.Split(s.Public, ".")[0]
+  return base64.StdEncoding.DecodeString(b64)
+}
+
+/* Short form of a public key, as "SHA256:" and the base64 digest of the
+   raw key. */
+func Fingerprint(public string) (string, error) {
+  raw, err := base64.StdEncoding.DecodeString(strings.Split(public, ".")[0])
+  if err != nil { return "", err }
+  sum := sha256.Sum256(raw)
+  return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:]), nil
+}
+
+/* Check that the private key is well formed and matches the public key. */
+func (s *KeyPair) Check() error {
+  if s.Curve != "" && s.Curve != "ed25519" {
+    return fmt.Errorf("unsupported curve %q", s.Curve)
+  }
+  pub, err := s.RawPublic()
+  if err != nil { return fmt.Errorf("bad public key: %v", err) }
+  pri, err := s.RawPrivate()
+  if err != nil { return fmt.Errorf("bad private key: %v", err) }
+  if len(pub) != ed25519.PublicKeySize { return errors.New("bad public key size") }
+  if len(pri) != ed25519.PrivateKeySize { return errors.New("bad private key size") }
+  derived := ed25519.NewKeyFromSeed(pri[:ed25519.SeedSize]).Public().(ed25519.PublicKey)
+  if !bytes.Equal(derived, pub) || !bytes.Equal(pri[ed25519.SeedSize:], pub) {
+    return errors.New("the private key does not match the public key")
+  }
+  msg := []byte("tc-node key check")
+  if !ed25519.Verify(pub, msg, ed25519.Sign(ed25519.PrivateKey(pri), msg)) {
+    return errors.New("signature check failed")
+  }
+  return nil
+}
+
 func New () (res *KeyPair, err error) {
   pub, pri, err := ed25519.GenerateKey(rand.Reader)
   if err != nil { return nil, err }
@@ -47,11 +87,8 @@ func Read (filename string) (*KeyPair, error) {
   return &res, nil
 }
 
+/* Write the key pair, unencrypted, to a new file readable only by its
+   owner. */
 func (kp *KeyPair) Write (filename string) error {
-  file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
-  if err != nil { return err }
-  defer file.Close()
-  err = json.NewEncoder(file).Encode(kp)
-  if err != nil { return err }
-  return nil
+  return writeNew(filename, kp)
 }
@@ -0,0 +1,189 @@
+
+package keypair
+
+import (
+  "crypto/aes"
+  "crypto/cipher"
+  "crypto/rand"
+  "encoding/base64"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "golang.org/x/crypto/scrypt"
+)
+
+/* Encrypted key pair file.  The private key is sealed with AES-256-GCM
+   under a key derived from a passphrase with scrypt.  The public key is
+   kept in the clear, so that it can be shown without the passphrase, and
+   is authenticated as additional data. */
+type Keystore struct {
+  Version int `json:"version"`
+  Curve string `json:"curve"`
+  Public string `json:"public"`
+  Kdf string `json:"kdf"`
+  KdfParams ScryptParams `json:"kdfParams"`
+  Cipher string `json:"cipher"`
+  Nonce string `json:"nonce"`
+  Ciphertext string `json:"ciphertext"`
+}
+
+type ScryptParams struct {
+  N int `json:"n"`
+  R int `json:"r"`
+  P int `json:"p"`
+  Salt string `json:"salt"`
+}
+
+const keystoreVersion = 1
+
+/* scrypt parameters of new keystores (about 100ms and 32MiB), and the
+   largest ones accepted when reading one: a key file must not make us
+   spend more than about 1GiB of memory (128*N*R bytes) or a few seconds
+   of work (N*R*P). */
+const (
+  scryptN = 1 << 15
+  scryptR = 8
+  scryptP = 1
+  scryptMaxN = 1 << 20
+  scryptMaxR = 8
+  scryptMaxP = 16
+)
+
+var ErrBadPassphrase = errors.New("wrong passphrase, or corrupted key file")
+
+/* Called to obtain the passphrase of an encrypted key file. */
+type PassphraseFunc func() ([]byte, error)
+
+func Encrypt(kp *KeyPair, passphrase []byte) (*Keystore, error) {
+  var err error
+  salt := make([]byte, 32)
+  _, err = rand.Read(salt)
+  if err != nil { return nil, err }
+  ks := &Keystore{
+    Version: keystoreVersion,
+    Curve: kp.Curve,
+    Public: kp.Public,
+    Kdf: "scrypt",
+    KdfParams: ScryptParams{
+      N: scryptN, R: scryptR, P: scryptP,
+      Salt: base64.StdEncoding.EncodeToString(salt),
+    },
+    Cipher: "aes-256-gcm",
+  }
+  aead, err := ks.aead(passphrase)
+  if err != nil { return nil, err }
+  nonce := make([]byte, aead.NonceSize())
+  _, err = rand.Read(nonce)
+  if err != nil { return nil, err }
+  sealed := aead.Seal(nil, nonce, []byte(kp.Private), []byte(kp.Public))
+  ks.Nonce = base64.StdEncoding.EncodeToString(nonce)
+  ks.Ciphertext = base64.StdEncoding.EncodeToString(sealed)
+  return ks, nil
+}
+
+func (ks *Keystore) Decrypt(passphrase []byte) (*KeyPair, error) {
+  if ks.Version != keystoreVersion {
+    return nil, fmt.Errorf("unsupported key file version %d", ks.Version)
+  }
+  aead, err := ks.aead(passphrase)
+  if err != nil { return nil, err }
+  nonce, err := base64.StdEncoding.DecodeString(ks.Nonce)
+  if err != nil || len(nonce) != aead.NonceSize() {
+    return nil, errors.New("bad nonce in key file")
+  }
+  sealed, err := base64.StdEncoding.DecodeString(ks.Ciphertext)
+  if err != nil { return nil, errors.New("bad ciphertext in key file") }
+  private, err := aead.Open(nil, nonce, sealed, []byte(ks.Public))
+  if err != nil { return nil, ErrBadPassphrase }
+  return &KeyPair{Curve: ks.Curve, Public: ks.Public, Private: string(private)}, nil
+}
+
+/* The cipher keyed from the passphrase. */
+func (ks *Keystore) aead(passphrase []byte) (cipher.AEAD, error) {
+  if ks.Kdf != "scrypt" { return nil, fmt.Errorf("unsupported kdf %q", ks.Kdf) }
+  if ks.Cipher != "aes-256-gcm" { return nil, fmt.Errorf("unsupported cipher %q", ks.Cipher) }
+  params := ks.KdfParams
+  if params.N > scryptMaxN { return nil, fmt.Errorf("scrypt N too large (%d)", params.N) }
+  if params.R < 1 || params.R > scryptMaxR { return nil, fmt.Errorf("bad scrypt r (%d)", params.R) }
+  if params.P < 1 || params.P > scryptMaxP { return nil, fmt.Errorf("bad scrypt p (%d)", params.P) }
+  salt, err := base64.StdEncoding.DecodeString(params.Salt)
+  if err != nil { return nil, errors.New("bad salt in key file") }
+  key, err := scrypt.Key(passphrase, salt, params.N, params.R, params.P, 32)
+  if err != nil { return nil, err }
+  block, err := aes.NewCipher(key)
+  if err != nil { return nil, err }
+  return cipher.NewGCM(block)
+}
+
+/* Write the keystore to a new file, readable only by its owner. */
+func (ks *Keystore) Write(filename string) error {
+  return writeNew(filename, ks)
+}
+
+func (kp *KeyPair) WriteEncrypted(filename string, passphrase []byte) error {
+  ks, err := Encrypt(kp, passphrase)
+  if err != nil { return err }
+  return ks.Write(filename)
+}
+
+/* Read a key file, plain or encrypted; passphrase is only called for
+   encrypted files, which cannot be read if it is nil. */
+func Load(filename string, passphrase PassphraseFunc) (*KeyPair, error) {
+  ks, kp, err := readFile(filename)
+  if err != nil { return nil, err }
+  if ks == nil { return kp, nil }
+  if passphrase == nil { return nil, fmt.Errorf("%s is encrypted", filename) }
+  pass, err := passphrase()
+  if err != nil { return nil, err }
+  return ks.Decrypt(pass)
+}
+
+/* The public key of a key file, without decrypting it. */
+func ReadPublic(filename string) (string, error) {
+  ks, kp, err := readFile(filename)
+  if err != nil { return "", err }
+  if ks != nil { return ks.Public, nil }
+  return kp.Public, nil
+}
+
+/* Whether a key file is encrypted. */
+func IsEncrypted(filename string) (bool, error) {
+  ks, _, err := readFile(filename)
+  if err != nil { return false, err }
+  return ks != nil, nil
+}
+
+/* Returns the keystore of an encrypted file, or the key pair of a plain
+   one. */
+func readFile(filename string) (*Keystore, *KeyPair, error) {
+  b, err := ioutil.ReadFile(filename)
+  if err != nil { return nil, nil, err }
+  var ks Keystore
+  err = json.Unmarshal(b, &ks)
+  if err != nil { return nil, nil, fmt.Errorf("%s: %v", filename, err) }
+  if ks.Ciphertext != "" { return &ks, nil, nil }
+  var kp KeyPair
+  err = json.Unmarshal(b, &kp)
+  if err != nil { return nil, nil, fmt.Errorf("%s: %v", filename, err) }
+  if kp.Private == "" { return nil, nil, fmt.Errorf("%s: no private key", filename) }
+  return nil, &kp, nil
+}
+
+/* Write v as JSON to a new file; the file is removed if it could not be
+   written completely. */
+func writeNew(filename string, v interface{}) error {
+  file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+  if err != nil { return err }
+  enc := json.NewEncoder(file)
+  enc.SetIndent("", "  ")
+  err = enc.Encode(v)
+  closeErr := file.Close()
+  if err == nil { err = closeErr }
+  if err != nil {
+    os.Remove(filename)
+    return err
+  }
+  return nil
+}
@@ -0,0 +1,114 @@
+
+package keypair
+
+import (
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "testing"
+)
+
+var testPassphrase = []byte("correct horse battery staple")
+
+func encryptTestKey(t *testing.T) (*KeyPair, *Keystore) {
+  kp, err := New()
+  if err != nil { t.Fatal(err) }
+  ks, err := Encrypt(kp, testPassphrase)
+  if err != nil { t.Fatal(err) }
+  return kp, ks
+}
+
+func TestKeystoreRoundTrip(t *testing.T) {
+  kp, ks := encryptTestKey(t)
+  if ks.Public != kp.Public { t.Errorf("public key %q, want %q", ks.Public, kp.Public) }
+  got, err := ks.Decrypt(testPassphrase)
+  if err != nil { t.Fatal(err) }
+  if *got != *kp { t.Errorf("decrypted %+v, want %+v", got, kp) }
+}
+
+func TestKeystoreWrongPassphrase(t *testing.T) {
+  _, ks := encryptTestKey(t)
+  _, err := ks.Decrypt([]byte("wrong"))
+  if err != ErrBadPassphrase { t.Errorf("got %v, want ErrBadPassphrase", err) }
+}
+
+/* The public key is authenticated: a key file cannot be made to claim
+   another public key. */
+func TestKeystoreTamperedPublic(t *testing.T) {
+  _, ks := encryptTestKey(t)
+  other, err := New()
+  if err != nil { t.Fatal(err) }
+  ks.Public = other.Public
+  _, err = ks.Decrypt(testPassphrase)
+  if err != ErrBadPassphrase { t.Errorf("got %v, want ErrBadPassphrase", err) }
+}
+
+func TestKeystoreUnsupported(t *testing.T) {
+  var tests = []struct {
+    name string
+    change func(ks *Keystore)
+  }{
+    {"version", func(ks *Keystore) { ks.Version = 2 }},
+    {"kdf", func(ks *Keystore) { ks.Kdf = "pbkdf2" }},
+    {"cipher", func(ks *Keystore) { ks.Cipher = "aes-128-gcm" }},
+  }
+  _, ks := encryptTestKey(t)
+  for _, test := range tests {
+    changed := *ks
+    test.change(&changed)
+    _, err := changed.Decrypt(testPassphrase)
+    if err == nil || err == ErrBadPassphrase {
+      t.Errorf("%s: got %v, want a rejection", test.name, err)
+    }
+  }
+}
+
+/* Parameters beyond the bounds are rejected before running scrypt. */
+func TestKeystoreScryptBounds(t *testing.T) {
+  var tests = []struct {
+    name string
+    n, r, p int
+  }{
+    {"n too large", scryptMaxN * 2, scryptR, scryptP},
+    {"r zero", scryptN, 0, scryptP},
+    {"r too large", scryptN, scryptMaxR + 1, scryptP},
+    {"p zero", scryptN, scryptR, 0},
+    {"p too large", scryptN, scryptR, scryptMaxP + 1},
+    {"n not a power of 2", scryptN + 1, scryptR, scryptP},
+  }
+  _, ks := encryptTestKey(t)
+  for _, test := range tests {
+    changed := *ks
+    changed.KdfParams.N = test.n
+    changed.KdfParams.R = test.r
+    changed.KdfParams.P = test.p
+    _, err := changed.Decrypt(testPassphrase)
+    if err == nil || err == ErrBadPassphrase {
+      t.Errorf("%s: got %v, want a rejection", test.name, err)
+    }
+  }
+}
+
+func TestKeystoreFile(t *testing.T) {
+  dir, err := ioutil.TempDir("", "keystore")
+  if err != nil { t.Fatal(err) }
+  defer os.RemoveAll(dir)
+  filename := filepath.Join(dir, "team.json")
+  kp, err := New()
+  if err != nil { t.Fatal(err) }
+  err = kp.WriteEncrypted(filename, testPassphrase)
+  if err != nil { t.Fatal(err) }
+  encrypted, err := IsEncrypted(filename)
+  if err != nil || !encrypted { t.Errorf("IsEncrypted: %v, %v", encrypted, err) }
+  public, err := ReadPublic(filename)
+  if err != nil || public != kp.Public { t.Errorf("ReadPublic: %q, %v", public, err) }
+  _, err = Load(filename, nil)
+  if err == nil { t.Error("Load without a passphrase: no error") }
+  got, err := Load(filename, func() ([]byte, error) { return testPassphrase, nil })
+  if err != nil { t.Fatal(err) }
+  if *got != *kp { t.Errorf("loaded %+v, want %+v", got, kp) }
+  /* An existing file is never overwritten, nor removed. */
+  err = kp.WriteEncrypted(filename, testPassphrase)
+  if err == nil { t.Error("overwrote an existing key file") }
+  if _, err := os.Stat(filename); err != nil { t.Errorf("existing key file: %v", err) }
+}
@@ -1,183 +1,374 @@
 
 package message
 
+/*
+  Canonical JSON, the form in which messages are signed.  It is the output
+  of JavaScript's JSON.stringify(value, null, 2) on the parsed message, with
+  the keys of every object sorted:
+
+  - objects and arrays: "{" or "[", then one member per line, indented by
+    two spaces per level, separated by ",", and the closing bracket on its
+    own line at the indentation of the opening one; members are written
+    as "key": value; empty objects and arrays are written "{}" and "[]";
+  - object keys are sorted in the order of their UTF-16 code units (the
+    default order of JavaScript's sort), except that the keys that are
+    array indices (the decimal form of an integer below 2^32 - 1, without
+    leading zeros) come first, in numeric order, as JavaScript objects
+    enumerate them; duplicate keys are an error;
+  - strings: '"' and '\' are escaped as \" and \\, the control characters
+    U+0008, U+0009, U+000A, U+000C, U+000D as \b, \t, \n, \f, \r, the other
+    characters below U+0020 as \u00xx (lowercase hexadecimal); all other
+    characters are written as is, in UTF-8; invalid UTF-8 in the input,
+    and \u escapes of unpaired surrogates (which Go strings cannot hold),
+    are errors;
+  - numbers are read as IEEE 754 doubles and written as by JavaScript's
+    Number.prototype.toString: the shortest digits that read back as the
+    same double, in positional notation when the decimal exponent is
+    between -7 and 20 (1e21 is written "1e+21", 1e-7 "1e-7"), "0" for
+    negative zero; numbers out of range are an error;
+  - true, false and null are written as is;
+  - no newline follows the value, and nothing may follow it in the input.
+*/
+
 import (
   "bytes"
   "encoding/json"
   "fmt"
   "io"
+  "math"
+  "sort"
+  "strconv"
   "strings"
-  "github.com/golang-collections/collections/stack"
+  "unicode/utf16"
+  "unicode/utf8"
   "github.com/pkg/errors"
 )
 
-type Encoder struct {
-  dec *json.Decoder
+/* Writes values in canonical form. */
+type encoder struct {
   out *bytes.Buffer
-  nesting *stack.Stack
-  firstItem bool
   depth int
-  signature *string
 }
 
-func (r *Encoder) Value() (err error) {
-  var t json.Token
-  t, err = r.Token()
-  if err != nil { return }
-  switch v := t.(type) {
-  case json.Delim: // [ ] { }
-    switch v {
-    case '{':
-      r.Object()
-      r.Token()
-    case '[':
-      r.Array()
-      r.Token()
-    default:
-      return errors.Errorf("unexpected token %v", v)
-    }
+/* Object member. */
+type member struct {
+  key string
+  value interface{}
+}
+
+func newEncoder() *encoder {
+  return &encoder{out: new(bytes.Buffer)}
+}
+
+/* Write a value as decoded by decodeValue: []member, []interface{},
+   string, json.Number, bool or nil. */
+func (r *encoder) value(v interface{}) error {
+  switch v := v.(type) {
+  case []member:
+    return r.object(v)
+  case []interface{}:
+    return r.array(v)
   case string:
-    fmt.Fprintf(r.out, "%q", v)
-  case float64:
-    fmt.Fprintf(r.out, "%v", v)
-  default:
-    if v == nil {
-      fmt.Fprint(r.out, "null")
+    return r.string(v)
+  case json.Number:
+    return r.number(v)
+  case bool:
+    if v {
+      r.out.WriteString("true")
     } else {
-      fmt.Fprintf(r.out, "%v", v)
+      r.out.WriteString("false")
     }
+  case nil:
+    r.out.WriteString("null")
+  default:
+    return errors.Errorf("unexpected value %v", v)
   }
   return nil
 }
 
-func (r *Encoder) Token() (res interface{}, err error) {
-  res, err = r.dec.Token()
-  if err == io.EOF {
-    res = r.nesting.Pop()
-    if res == nil { return }
-    err = nil
-  } else if err != nil {
-    return
+func (r *encoder) object(members []member) error {
+  if len(members) == 0 {
+    r.out.WriteString("{}")
+    return nil
   }
-  switch v := res.(type) {
-  case rune:
-    switch v {
-    case '}':
-      r.CloseObject()
-    case ']':
-      r.CloseArray()
-    default:
-      return nil, errors.New("bad delimiter on stack")
-    }
-  case json.Delim: // [ ] { }
-    switch v {
-    case '{':
-      r.depth++
-      r.nesting.Push('}')
-    case '}':
-      r.CloseObject()
-    case '[':
-      r.depth++
-      r.nesting.Push(']')
-    case ']':
-      r.CloseArray()
-    default:
-      return nil, errors.New("bad delimiter")
+  sorted := make([]member, len(members))
+  copy(sorted, members)
+  sort.SliceStable(sorted, func (i, j int) bool {
+    return lessKey(sorted[i].key, sorted[j].key)
+  })
+  for i := 1; i < len(sorted); i++ {
+    if sorted[i].key == sorted[i - 1].key {
+      return errors.Errorf("duplicate key %q", sorted[i].key)
     }
   }
-  return
+  r.out.WriteString("{\n")
+  r.depth++
+  for i, m := range sorted {
+    if i != 0 { r.out.WriteString(",\n") }
+    r.indent()
+    if err := r.string(m.key); err != nil { return err }
+    r.out.WriteString(": ")
+    if err := r.value(m.value); err != nil { return err }
+  }
+  r.depth--
+  r.out.WriteString("\n")
+  r.indent()
+  r.out.WriteString("}")
+  return nil
 }
 
-func (r *Encoder) Array() (err error) {
-  fmt.Fprintf(r.out, "[\n")
-  r.firstItem = true
-  for r.dec.More() {
-    r.Item()
-    if err = r.Value(); err != nil { return }
+func (r *encoder) array(items []interface{}) error {
+  if len(items) == 0 {
+    r.out.WriteString("[]")
+    return nil
+  }
+  r.out.WriteString("[\n")
+  r.depth++
+  for i, item := range items {
+    if i != 0 { r.out.WriteString(",\n") }
+    r.indent()
+    if err := r.value(item); err != nil { return err }
   }
+  r.depth--
+  r.out.WriteString("\n")
+  r.indent()
+  r.out.WriteString("]")
   return nil
 }
 
-func (r *Encoder) CloseArray() {
-  r.depth--
-  if (r.firstItem) {
-    r.firstItem = false;
-  } else {
-    fmt.Fprintf(r.out, "\n")
-    fmt.Fprint(r.out, strings.Repeat("  ", r.depth))
+func (r *encoder) string(s string) error {
+  if !utf8.ValidString(s) {
+    return errors.Errorf("invalid UTF-8 in string %q", s)
   }
-  fmt.Fprintf(r.out, "]")
+  r.out.WriteByte('"')
+  for _, c := range s {
+    switch c {
+    case '"':
+      r.out.WriteString("\\\"")
+    case '\\':
+      r.out.WriteString("\\\\")
+    case '\b':
+      r.out.WriteString("\\b")
+    case '\t':
+      r.out.WriteString("\\t")
+    case '\n':
+      r.out.WriteString("\\n")
+    case '\f':
+      r.out.WriteString("\\f")
+    case '\r':
+      r.out.WriteString("\\r")
+    default:
+      if c < 0x20 {
+        fmt.Fprintf(r.out, "\\u%04x", c)
+      } else {
+        r.out.WriteRune(c)
+      }
+    }
+  }
+  r.out.WriteByte('"')
+  return nil
 }
 
-func (r *Encoder) Object() (err error) {
-  fmt.Fprintf(r.out, "{\n")
-  r.firstItem = true
-  for r.dec.More() {
-    r.Item()
-    if err = r.Key(); err != nil { return }
-    if err = r.Value(); err != nil { return }
-  }
+func (r *encoder) number(n json.Number) error {
+  f, err := strconv.ParseFloat(string(n), 64)
+  if err != nil { return errors.Errorf("bad number %s", n) }
+  s, err := FormatNumber(f)
+  if err != nil { return err }
+  r.out.WriteString(s)
   return nil
 }
 
-func (r *Encoder) CloseObject() {
-  r.depth--
-  if (r.firstItem) {
-    r.firstItem = false;
-  } else {
-    fmt.Fprintf(r.out, "\n")
-    fmt.Fprint(r.out, strings.Repeat("  ", r.depth))
+func (r *encoder) indent() {
+  r.out.WriteString(strings.Repeat("  ", r.depth))
+}
+
+func (r *encoder) bytes() []byte {
+  return r.out.Bytes()
+}
+
+/* Format a double as JavaScript's Number.prototype.toString. */
+func FormatNumber(f float64) (string, error) {
+  if math.IsNaN(f) || math.IsInf(f, 0) {
+    return "", errors.Errorf("number out of range: %v", f)
   }
-  fmt.Fprintf(r.out, "}")
+  if f == 0 { return "0", nil }
+  sign := ""
+  if f < 0 {
+    sign = "-"
+    f = -f
+  }
+  /* Shortest digits d1.d2...dk and exponent, as "d.ddde±x". */
+  e := strconv.FormatFloat(f, 'e', -1, 64)
+  mant, exp := e[:strings.IndexByte(e, 'e')], e[strings.IndexByte(e, 'e') + 1:]
+  digits := strings.Replace(mant, ".", "", 1)
+  x, err := strconv.Atoi(exp)
+  if err != nil { return "", err }
+  k := len(digits)
+  n := x + 1 /* position of the decimal point */
+  var res string
+  switch {
+  case k <= n && n <= 21:
+    res = digits + strings.Repeat("0", n - k)
+  case 0 < n && n <= 21:
+    res = digits[:n] + "." + digits[n:]
+  case -6 < n && n <= 0:
+    res = "0." + strings.Repeat("0", -n) + digits
+  default:
+    expSign := "+"
+    if n - 1 < 0 { expSign = "-" }
+    res = digits[:1]
+    if k > 1 { res += "." + digits[1:] }
+    res += "e" + expSign + strconv.Itoa(abs(n - 1))
+  }
+  return sign + res, nil
+}
+
+func abs(n int) int {
+  if n < 0 { return -n }
+  return n
+}
+
+/* Order of the keys of a JavaScript object: array indices first, in
+   numeric order, then the other keys in the order they were inserted,
+   which is the sorted order. */
+func lessKey(a, b string) bool {
+  ia, aIndex := arrayIndex(a)
+  ib, bIndex := arrayIndex(b)
+  if aIndex || bIndex {
+    if aIndex && bIndex { return ia < ib }
+    return aIndex
+  }
+  return lessUtf16(a, b)
 }
 
-func (r *Encoder) Item() {
-  if (r.firstItem) {
-    r.firstItem = false
-  } else {
-    fmt.Fprint(r.out, ",\n")
+/* Whether key is an array index in JavaScript: the canonical decimal form
+   of an integer below 2^32 - 1. */
+func arrayIndex(key string) (uint64, bool) {
+  if key == "" || len(key) > 10 || (key[0] == '0' && key != "0") { return 0, false }
+  for i := 0; i < len(key); i++ {
+    if key[i] < '0' || key[i] > '9' { return 0, false }
   }
-  fmt.Fprint(r.out, strings.Repeat("  ", r.depth))
+  n, err := strconv.ParseUint(key, 10, 64)
+  if err != nil || n >= 1<<32 - 1 { return 0, false }
+  return n, true
 }
 
-func (r *Encoder) Key() error {
-  t, err := r.Token()
-  if err != nil {
-    return err
+/* Compare strings by their UTF-16 code units. */
+func lessUtf16(a, b string) bool {
+  ua, ub := utf16.Encode([]rune(a)), utf16.Encode([]rune(b))
+  for i := 0; i < len(ua) && i < len(ub); i++ {
+    if ua[i] != ub[i] { return ua[i] < ub[i] }
   }
+  return len(ua) < len(ub)
+}
+
+/* Read the next value of the stream, keeping the members of objects in
+   their original order. */
+func decodeValue(dec *json.Decoder) (interface{}, error) {
+  t, err := dec.Token()
+  if err != nil { return nil, err }
   switch v := t.(type) {
-  case string:
-    fmt.Fprintf(r.out, "%q: ", v)
-    return nil
+  case json.Delim:
+    switch v {
+    case '{':
+      var members = []member{}
+      for dec.More() {
+        t, err = dec.Token()
+        if err != nil { return nil, err }
+        key, ok := t.(string)
+        if !ok { return nil, errors.Errorf("expected key, got %v", t) }
+        value, err := decodeValue(dec)
+        if err != nil { return nil, err }
+        members = append(members, member{key, value})
+      }
+      _, err = dec.Token() /* } */
+      if err != nil { return nil, err }
+      return members, nil
+    case '[':
+      var items = []interface{}{}
+      for dec.More() {
+        item, err := decodeValue(dec)
+        if err != nil { return nil, err }
+        items = append(items, item)
+      }
+      _, err = dec.Token() /* ] */
+      if err != nil { return nil, err }
+      return items, nil
+    default:
+      return nil, errors.Errorf("unexpected token %v", v)
+    }
   default:
-    return errors.Errorf("expected key, got %v", v)
+    return v, nil
   }
 }
 
-func (r *Encoder) Bytes() []byte {
-  return r.out.Bytes()
+/* Check the text of a document for what encoding/json would silently
+   replace by U+FFFD: invalid UTF-8, and escaped unpaired surrogates. */
+func checkText(b []byte) error {
+  if !utf8.Valid(b) { return errors.New("invalid UTF-8") }
+  inString := false
+  for i := 0; i < len(b); i++ {
+    switch {
+    case !inString:
+      if b[i] == '"' { inString = true }
+    case b[i] == '"':
+      inString = false
+    case b[i] == '\\':
+      i++
+      if i >= len(b) || b[i] != 'u' { continue }
+      c, ok := hexEscape(b, i - 1)
+      if !ok { continue } /* left to the decoder */
+      i += 4
+      if !utf16.IsSurrogate(c) { continue }
+      c2, ok := hexEscape(b, i + 1)
+      if c >= 0xdc00 || !ok || utf16.DecodeRune(c, c2) == utf8.RuneError {
+        return errors.Errorf("unpaired surrogate \\u%04x", c)
+      }
+      i += 6
+    }
+  }
+  return nil
+}
+
+/* The code unit of the escape \uXXXX at b[i:]. */
+func hexEscape(b []byte, i int) (rune, bool) {
+  if i + 6 > len(b) || b[i] != '\\' || b[i + 1] != 'u' { return 0, false }
+  n, err := strconv.ParseUint(string(b[i + 2:i + 6]), 16, 16)
+  if err != nil { return 0, false }
+  return rune(n), true
 }
 
+/* Re-encode a JSON document in canonical form. */
 func Encode(b []byte) ([]byte, error) {
-  r := Encoder{
-    dec: json.NewDecoder(bytes.NewReader(b)),
-    out: new(bytes.Buffer),
-    nesting: stack.New(),
-    firstItem: false,
-    depth: 0,
-    signature: nil,
-  }
-  err := r.Value()
-  if err != nil {
-    return []byte{}, err
+  if err := checkText(b); err != nil { return nil, errors.Wrap(err, "canonical encoding") }
+  dec := json.NewDecoder(bytes.NewReader(b))
+  dec.UseNumber()
+  value, err := decodeValue(dec)
+  if err != nil { return nil, errors.Wrap(err, "canonical encoding") }
+  if _, err = dec.Token(); err != io.EOF {
+    return nil, errors.New("canonical encoding: data after the value")
   }
-  return r.Bytes(), nil
+  r := newEncoder()
+  err = r.value(value)
+  if err != nil { return nil, errors.Wrap(err, "canonical encoding") }
+  return r.bytes(), nil
 }
 
-func InjectSignature(b []byte, sig string) []byte {
+/* Add the signature as the last member of a canonical object. */
+func InjectSignature(b []byte, sig string) ([]byte, error) {
+  var r = newEncoder()
+  err := r.string(sig)
+  if err != nil { return nil, err }
+  field := "  \"signature\": " + string(r.bytes())
   out := new(bytes.Buffer)
-  l := len(b)
-  out.Write(b[:l - 2])
-  fmt.Fprintf(out, ",\n  %q: %q\n}", "signature", sig)
-  return out.Bytes()
+  switch {
+  case bytes.Equal(b, []byte("{}")):
+    out.WriteString("{\n" + field + "\n}")
+  case bytes.HasPrefix(b, []byte("{\n")) && bytes.HasSuffix(b, []byte("\n}")):
+    out.Write(b[:len(b) - 2])
+    out.WriteString(",\n" + field + "\n}")
+  default:
+    return nil, errors.New("can only sign a canonical object")
+  }
+  return out.Bytes(), nil
 }
@@ -0,0 +1,141 @@
+
+package message
+
+import (
+  "encoding/json"
+  "io/ioutil"
+  "path/filepath"
+  "testing"
+)
+
+func TestEncode(t *testing.T) {
+  var tests = []struct {
+    name string
+    in string
+    out string
+  }{
+    {"key order", `{"b": 1, "a": 2, "B": 3, "aa": 4}`,
+      "{\n  \"B\": 3,\n  \"a\": 2,\n  \"aa\": 4,\n  \"b\": 1\n}"},
+    /* U+1F600 is D83D DE00 in UTF-16, before U+E000 and U+FFFF, although
+       its code point is larger. */
+    {"non-BMP keys", `{"\uffff": 1, "\ud83d\ude00": 2, "\ue000": 3, "z": 4}`,
+      "{\n  \"z\": 4,\n  \"\U0001f600\": 2,\n  \"\ue000\": 3,\n  \"\uffff\": 1\n}"},
+    {"nested", `{"b": {"d": [1, {"f": null, "e": true}], "c": false}, "a": "x"}`,
+      "{\n  \"a\": \"x\",\n  \"b\": {\n    \"c\": false,\n    \"d\": [\n      1,\n" +
+      "      {\n        \"e\": true,\n        \"f\": null\n      }\n    ]\n  }\n}"},
+    {"control characters", `"\u0000\u0001\b\t\n\u000b\f\r\u001f"`,
+      `"\u0000\u0001\b\t\n\u000b\f\r\u001f"`},
+    {"escapes", `"\"\\\/Aé\u007f "`,
+      "\"\\\"\\\\/Aé\u007f \""},
+    {"surrogate pair", `"\ud83d\ude00"`, "\"\U0001f600\""},
+    {"1e21", `1e21`, `1e+21`},
+    {"1e20", `1e20`, `100000000000000000000`},
+    {"1e-7", `1e-7`, `1e-7`},
+    {"0.000001", `0.000001`, `0.000001`},
+    {"-0", `-0`, `0`},
+    {"-0.0", `-0.0`, `0`},
+    {"2^53+1", `9007199254740993`, `9007199254740992`},
+    {"negative", `-1.50`, `-1.5`},
+    {"exponent", `1.2345e3`, `1234.5`},
+    {"small", `-1.5e-10`, `-1.5e-10`},
+    {"empty object", `{}`, `{}`},
+    {"empty array", `[]`, `[]`},
+    {"empty members", `{"a": {}, "b": []}`, "{\n  \"a\": {},\n  \"b\": []\n}"},
+    {"trailing space", "{\"a\": 1}\n", "{\n  \"a\": 1\n}"},
+  }
+  for _, test := range tests {
+    out, err := Encode([]byte(test.in))
+    if err != nil {
+      t.Errorf("%s: %v", test.name, err)
+      continue
+    }
+    if string(out) != test.out {
+      t.Errorf("%s: got %q, want %q", test.name, out, test.out)
+    }
+  }
+}
+
+/* Outputs of the reference implementation, JSON.stringify with sorted keys
+   (see testdata/canonical.js, which generates canonical.json). */
+func TestEncodeReference(t *testing.T) {
+  bs, err := ioutil.ReadFile(filepath.Join("testdata", "canonical.json"))
+  if err != nil { t.Fatal(err) }
+  var cases []struct {
+    In string `json:"in"`
+    Out string `json:"out"`
+  }
+  err = json.Unmarshal(bs, &cases)
+  if err != nil { t.Fatal(err) }
+  if len(cases) == 0 { t.Fatal("no cases in canonical.json") }
+  for _, c := range cases {
+    out, err := Encode([]byte(c.In))
+    if err != nil {
+      t.Errorf("%s: %v", c.In, err)
+      continue
+    }
+    if string(out) != c.Out {
+      t.Errorf("%s: got %q, want %q", c.In, out, c.Out)
+    }
+  }
+}
+
+func TestEncodeErrors(t *testing.T) {
+  var tests = []struct {
+    name string
+    in string
+  }{
+    {"duplicate keys", `{"a": 1, "b": 2, "a": 3}`},
+    {"nested duplicate keys", `{"a": {"b": 1, "b": 1}}`},
+    {"trailing value", `{} {}`},
+    {"trailing garbage", `{"a": 1}x`},
+    {"trailing number", `1 2`},
+    {"invalid UTF-8", "\"\xff\""},
+    {"lone high surrogate", `"\ud83d"`},
+    {"lone low surrogate", `"\ude00"`},
+    {"reversed surrogates", `"\ude00\ud83d"`},
+    {"number out of range", `1e400`},
+    {"truncated", `{"a": `},
+    {"empty", ``},
+  }
+  for _, test := range tests {
+    out, err := Encode([]byte(test.in))
+    if err == nil {
+      t.Errorf("%s: expected an error, got %q", test.name, out)
+    }
+  }
+}
+
+func TestInjectSignature(t *testing.T) {
+  var tests = []struct {
+    name string
+    in string
+    out string
+  }{
+    {"empty object", `{}`, "{\n  \"signature\": \"S\"\n}"},
+    {"flat", `{"b": 2, "a": 1}`,
+      "{\n  \"a\": 1,\n  \"b\": 2,\n  \"signature\": \"S\"\n}"},
+    {"nested", `{"z": {"y": {}}, "a": [{"b": 1}]}`,
+      "{\n  \"a\": [\n    {\n      \"b\": 1\n    }\n  ],\n  \"z\": {\n    \"y\": {}\n  },\n" +
+      "  \"signature\": \"S\"\n}"},
+  }
+  for _, test := range tests {
+    encoded, err := Encode([]byte(test.in))
+    if err != nil {
+      t.Errorf("%s: %v", test.name, err)
+      continue
+    }
+    out, err := InjectSignature(encoded, "S")
+    if err != nil {
+      t.Errorf("%s: %v", test.name, err)
+      continue
+    }
+    if string(out) != test.out {
+      t.Errorf("%s: got %q, want %q", test.name, out, test.out)
+    }
+  }
+  for _, in := range []string{`[]`, `"x"`, `{"a":1}`} {
+    if _, err := InjectSignature([]byte(in), "S"); err == nil {
+      t.Errorf("InjectSignature(%q): expected an error", in)
+    }
+  }
+}
@@ -15,7 +15,10 @@ func Sign(keys *keypair.KeyPair, apiKey string, obj interface{}) ([]byte, error)
   var err error
   var res []byte
   plain := new(bytes.Buffer)
-  json.NewEncoder(plain).Encode(obj)
+  err = json.NewEncoder(plain).Encode(obj)
+  if err != nil {
+    return res, err
+  }
   var encoded []byte
   encoded, err = Encode(plain.Bytes())
   if err != nil {
@@ -26,13 +29,19 @@ func Sign(keys *keypair.KeyPair, apiKey string, obj interface{}) ([]byte, error)
   if err != nil {
     return res, err
   }
+  rawSig := ed25519.Sign(rawPriv, digest(apiKey, encoded))
+  encSig := base64.StdEncoding.EncodeToString(rawSig) + signatureSuffix
+  return InjectSignature(encoded, encSig)
+}
+
+const signatureSuffix = ".sig.ed25519"
+
+/* The signed digest of a canonical message: the first half of its
+   HMAC-SHA512 under the (base64) API key. */
+func digest(apiKey string, encoded []byte) []byte {
   gameApiKey, _ :=  base64.StdEncoding.DecodeString(apiKey)
   hasher := hmac.New(sha512.New, []byte(gameApiKey))
   hasher.Write([]byte(encoded))
-  hash := hasher.Sum(nil)[:32]
-  rawSig := ed25519.Sign(rawPriv, hash)
-  encSig := base64.StdEncoding.EncodeToString(rawSig) + ".sig.ed25519"
-  res = InjectSignature(encoded, encSig)
-  return res, nil
+  return hasher.Sum(nil)[:32]
 }
 
@@ -0,0 +1,59 @@
+// Generates canonical.json, the reference outputs of the message encoding:
+// JSON.stringify with the object keys sorted and an indent of 2, as done
+// by the server when it checks a signature.
+//   node canonical.js > canonical.json
+'use strict';
+
+function sortKeys(value) {
+  if (Array.isArray(value)) return value.map(sortKeys);
+  if (value === null || typeof value !== 'object') return value;
+  const sorted = {};
+  for (const key of Object.keys(value).sort()) sorted[key] = sortKeys(value[key]);
+  return sorted;
+}
+
+function canonical(text) {
+  return JSON.stringify(sortKeys(JSON.parse(text)), null, 2);
+}
+
+const inputs = [
+  // Key order: UTF-16 code units, so non-BMP characters (surrogate pairs,
+  // D800-DFFF) sort before U+E000-U+FFFF.
+  '{"b": 1, "a": 2, "B": 3, "aa": 4, "": 5, "a b": 6}',
+  '{"\\uffff": 1, "\\ud83d\\ude00": 2, "\\ue000": 3, "z": 4}',
+  '{"\\ud800\\udc00": 1, "\\ud7ff": 2, "\\ufb01": 3, "\\udbff\\udfff": 4}',
+  '{"a\\ud83d\\ude00": 1, "a\\uffff": 2, "a": 3, "\\u00e9": 4, "e\\u0301": 5}',
+  // Keys that are array indices (integers below 2^32 - 1 without leading
+  // zeros) are enumerated first, in numeric order, whatever the insertion
+  // order.
+  '{"10": 1, "9": 2, "1": 3, "a": 4}',
+  '{"4294967295": 1, "4294967294": 2, "01": 3, "0": 4, "-1": 5, "1.0": 6, " 1": 7, "B": 8}',
+  '{"z": {"2": true, "10": false}, "100": [{"b": 1, "20": 2, "3": 3}]}',
+  // Nesting and empty containers.
+  '{"b": {"d": [1, {"f": null, "e": true}], "c": false}, "a": "x"}',
+  '{"a": {}, "b": [], "c": [[]], "d": [{}]}',
+  '{}',
+  '[]',
+  // Strings.
+  '"\\u0000\\u0001\\b\\t\\n\\u000b\\f\\r\\u001f"',
+  '"\\"\\\\\\/A\\u00e9\\u007f\\u0080 "',
+  '"\\u2028\\u2029"',
+  '"\\ud83d\\ude00 \\ud800\\udc00 \\udbff\\udfff"',
+  '"move 1\\nmove 2"',
+  // Numbers.
+  '0', '-0', '-0.0', '0.0', '1', '-1', '100', '1.50', '-1.5',
+  '0.1', '0.2', '0.3', '1.1', '123.456', '4.35', '1234567.0',
+  '1.2345e3', '1E3', '1e+3',
+  '1e20', '1e21', '1e22', '123456789012345680000', '1.5e300',
+  '0.000001', '0.0000001', '1e-7', '-1.5e-10', '0.000001234', '1.234e-7',
+  '9007199254740991', '9007199254740992', '9007199254740993', '-9007199254740993',
+  '18014398509481985', '12345678901234567890',
+  '5e-324', '2.2250738585072014e-308', '1.7976931348623157e308',
+  '0.30000000000000004', '3.141592653589793238', '2.718281828459045',
+  '[1e21, 1e-7, -0, 0.1]',
+  // A message as signed by the node.
+  '{"author": "@team", "round": 3, "commands": "move 1\\nmove 2", "player": 1, "stamp": 1.5}',
+];
+
+const cases = inputs.map(input => ({in: input, out: canonical(input)}));
+process.stdout.write(JSON.stringify(cases, null, 2) + '\n');
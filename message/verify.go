@@ -0,0 +1,92 @@
+
+package message
+
+import (
+  "bytes"
+  "encoding/base64"
+  "encoding/json"
+  "io"
+  "strings"
+  "golang.org/x/crypto/ed25519"
+  "github.com/pkg/errors"
+)
+
+var ErrNoSignature = errors.New("message has no signature")
+var ErrBadSignature = errors.New("bad signature")
+
+/* Check a message produced by Sign: remove its "signature" field,
+   re-encode the rest canonically, and check the ed25519 signature of its
+   digest against publicKey (in the "base64.ed25519" form of key pairs). */
+func Verify(publicKey string, apiKey string, signed []byte) error {
+  var err error
+  var rawPub []byte
+  rawPub, err = base64.StdEncoding.DecodeString(strings.Split(publicKey, ".")[0])
+  if err != nil || len(rawPub) != ed25519.PublicKeySize {
+    return errors.New("bad public key")
+  }
+  var unsigned []byte
+  var sig string
+  unsigned, sig, err = stripSignature(signed)
+  if err != nil { return err }
+  if !strings.HasSuffix(sig, signatureSuffix) {
+    return errors.Errorf("bad signature format %q", sig)
+  }
+  var rawSig []byte
+  rawSig, err = base64.StdEncoding.DecodeString(strings.TrimSuffix(sig, signatureSuffix))
+  if err != nil || len(rawSig) != ed25519.SignatureSize {
+    return errors.Errorf("bad signature format %q", sig)
+  }
+  var encoded []byte
+  encoded, err = Encode(unsigned)
+  if err != nil { return err }
+  if !ed25519.Verify(rawPub, digest(apiKey, encoded), rawSig) {
+    return ErrBadSignature
+  }
+  return nil
+}
+
+/* Split a signed message into the compact JSON of its other fields, in
+   their original order, and its signature. */
+func stripSignature(signed []byte) ([]byte, string, error) {
+  var err error
+  dec := json.NewDecoder(bytes.NewReader(signed))
+  var t json.Token
+  t, err = dec.Token()
+  if err != nil { return nil, "", err }
+  if t != json.Delim('{') { return nil, "", errors.New("signed message is not an object") }
+  out := new(bytes.Buffer)
+  out.WriteByte('{')
+  var sig *string
+  for dec.More() {
+    t, err = dec.Token()
+    if err != nil { return nil, "", err }
+    key, ok := t.(string)
+    if !ok { return nil, "", errors.Errorf("expected key, got %v", t) }
+    var value json.RawMessage
+    err = dec.Decode(&value)
+    if err != nil { return nil, "", err }
+    if key == "signature" {
+      if sig != nil { return nil, "", errors.New("duplicate signature") }
+      var s string
+      err = json.Unmarshal(value, &s)
+      if err != nil { return nil, "", errors.Wrap(err, "signature") }
+      sig = &s
+      continue
+    }
+    if out.Len() > 1 { out.WriteByte(',') }
+    var bs []byte
+    bs, err = json.Marshal(key)
+    if err != nil { return nil, "", err }
+    out.Write(bs)
+    out.WriteByte(':')
+    out.Write(value)
+  }
+  _, err = dec.Token() /* } */
+  if err != nil { return nil, "", err }
+  if _, err = dec.Token(); err != io.EOF {
+    return nil, "", errors.New("data after the signed message")
+  }
+  if sig == nil { return nil, "", ErrNoSignature }
+  out.WriteByte('}')
+  return out.Bytes(), *sig, nil
+}
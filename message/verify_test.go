@@ -0,0 +1,86 @@
+
+package message
+
+import (
+  "bytes"
+  "strings"
+  "testing"
+  "tezos-contests.izibi.com/tc-node/keypair"
+)
+
+const testApiKey = "c2VjcmV0IGFwaSBrZXk="
+
+type testMessage struct {
+  Author string `json:"author"`
+  Round int `json:"round"`
+  Commands string `json:"commands"`
+}
+
+func signTestMessage(t *testing.T) (*keypair.KeyPair, []byte) {
+  kp, err := keypair.New()
+  if err != nil { t.Fatal(err) }
+  signed, err := Sign(kp, testApiKey, testMessage{"@team", 3, "move 1\nmove 2"})
+  if err != nil { t.Fatal(err) }
+  return kp, signed
+}
+
+func TestVerify(t *testing.T) {
+  kp, signed := signTestMessage(t)
+  err := Verify(kp.Public, testApiKey, signed)
+  if err != nil { t.Errorf("round trip: %v", err) }
+  /* Verified on the content, whatever the layout. */
+  compact := new(bytes.Buffer)
+  for _, line := range bytes.Split(signed, []byte("\n")) {
+    compact.Write(bytes.TrimSpace(line))
+  }
+  err = Verify(kp.Public, testApiKey, compact.Bytes())
+  if err != nil { t.Errorf("compact: %v", err) }
+}
+
+func TestVerifyTampered(t *testing.T) {
+  kp, signed := signTestMessage(t)
+  tampered := bytes.Replace(signed, []byte(`"round": 3`), []byte(`"round": 4`), 1)
+  if bytes.Equal(tampered, signed) { t.Fatalf("no round in %s", signed) }
+  err := Verify(kp.Public, testApiKey, tampered)
+  if err != ErrBadSignature { t.Errorf("tampered field: got %v", err) }
+}
+
+func TestVerifyWrongKeys(t *testing.T) {
+  kp, signed := signTestMessage(t)
+  err := Verify(kp.Public, "b3RoZXIgYXBpIGtleQ==", signed)
+  if err != ErrBadSignature { t.Errorf("wrong API key: got %v", err) }
+  other, err := keypair.New()
+  if err != nil { t.Fatal(err) }
+  err = Verify(other.Public, testApiKey, signed)
+  if err != ErrBadSignature { t.Errorf("wrong public key: got %v", err) }
+}
+
+func TestVerifyMissingSignature(t *testing.T) {
+  kp, _ := signTestMessage(t)
+  unsigned := []byte(`{"author": "@team", "commands": "move 1\nmove 2", "round": 3}`)
+  err := Verify(kp.Public, testApiKey, unsigned)
+  if err != ErrNoSignature { t.Errorf("missing signature: got %v", err) }
+}
+
+func TestVerifyDuplicateSignature(t *testing.T) {
+  kp, signed := signTestMessage(t)
+  i := bytes.Index(signed, []byte(`  "signature"`))
+  if i < 0 { t.Fatalf("no signature in %s", signed) }
+  sigLine := signed[i:len(signed) - 2]
+  duplicated := append(append(append([]byte{}, signed[:i]...), sigLine...), ",\n"...)
+  duplicated = append(duplicated, signed[i:]...)
+  err := Verify(kp.Public, testApiKey, duplicated)
+  if err == nil || !strings.Contains(err.Error(), "duplicate signature") {
+    t.Errorf("duplicate signature: got %v", err)
+  }
+}
+
+func TestVerifyTrailingData(t *testing.T) {
+  kp, signed := signTestMessage(t)
+  for _, trailer := range []string{"{}", "x", `"signature"`} {
+    err := Verify(kp.Public, testApiKey, append(append([]byte{}, signed...), trailer...))
+    if err == nil { t.Errorf("trailing %q: verified", trailer) }
+  }
+  err := Verify(kp.Public, testApiKey, append(append([]byte{}, signed...), '\n'))
+  if err != nil { t.Errorf("trailing newline: %v", err) }
+}
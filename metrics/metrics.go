@@ -0,0 +1,100 @@
+/*
+  Prometheus metrics, served by the control API on /metrics.
+*/
+
+package metrics
+
+import (
+  "net/http"
+  "github.com/prometheus/client_golang/prometheus"
+  "github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "tc_node"
+
+var ApiRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+  Namespace: namespace,
+  Name: "api_request_duration_seconds",
+  Help: "Latency of API requests, by endpoint and outcome.",
+  Buckets: prometheus.DefBuckets,
+}, []string{"endpoint", "outcome"})
+
+var BotRunDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+  Namespace: namespace,
+  Name: "bot_run_duration_seconds",
+  Help: "Duration of bot runs, by bot id.",
+  Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60},
+}, []string{"bot_id"})
+
+var BotRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+  Namespace: namespace,
+  Name: "bot_runs_total",
+  Help: "Bot runs, by bot id and exit status.",
+}, []string{"bot_id", "exit_status"})
+
+var CommandsSent = prometheus.NewCounter(prometheus.CounterOpts{
+  Namespace: namespace,
+  Name: "commands_sent_total",
+  Help: "Commands accepted by the server.",
+})
+
+var CommandRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+  Namespace: namespace,
+  Name: "command_rejections_total",
+  Help: "Commands rejected by the server, by reason: block_changed, invalid_commands, request_failed or other.",
+}, []string{"reason"})
+
+var BlockDownloads = prometheus.NewCounter(prometheus.CounterOpts{
+  Namespace: namespace,
+  Name: "block_downloads_total",
+  Help: "Blocks downloaded into the store.",
+})
+
+var BlockBytes = prometheus.NewCounter(prometheus.CounterOpts{
+  Namespace: namespace,
+  Name: "block_download_bytes_total",
+  Help: "Bytes downloaded into the store.",
+})
+
+var SseReconnects = prometheus.NewCounter(prometheus.CounterOpts{
+  Namespace: namespace,
+  Name: "sse_reconnects_total",
+  Help: "Reconnections of the event stream.",
+})
+
+var ClockOffset = prometheus.NewGauge(prometheus.GaugeOpts{
+  Namespace: namespace,
+  Name: "clock_offset_seconds",
+  Help: "Estimated server clock minus local clock.",
+})
+
+var ClockRoundTrip = prometheus.NewGauge(prometheus.GaugeOpts{
+  Namespace: namespace,
+  Name: "clock_round_trip_seconds",
+  Help: "Round-trip time of the best clock samples.",
+})
+
+func init() {
+  prometheus.MustRegister(
+    ApiRequestDuration,
+    BotRunDuration,
+    BotRuns,
+    CommandsSent,
+    CommandRejections,
+    BlockDownloads,
+    BlockBytes,
+    SseReconnects,
+    ClockOffset,
+    ClockRoundTrip,
+  )
+}
+
+func Handler() http.Handler {
+  return promhttp.Handler()
+}
+
+/* Outcome label for a request or run. */
+func Outcome(err error) string {
+  if err != nil { return "error" }
+  return "ok"
+}
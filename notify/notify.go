@@ -0,0 +1,59 @@
+/*
+  Structured messages: a level, a human-readable message, and fields
+  giving context (game key, round, bot id, duration...).  Renderers decide
+  how to present them: the terminal shows the message, log files keep the
+  fields.
+*/
+
+package notify
+
+import (
+  "fmt"
+)
+
+type Level int
+
+const (
+  Debug Level = iota /* progress, replaced by the next message on a terminal */
+  Info
+  Warning
+  Error
+)
+
+var levelNames = []string{"debug", "info", "warning", "error"}
+
+func (l Level) String() string {
+  if l >= 0 && int(l) < len(levelNames) {
+    return levelNames[l]
+  }
+  return fmt.Sprintf("level(%d)", int(l))
+}
+
+func ParseLevel(s string) (Level, error) {
+  for i, name := range levelNames {
+    if s == name { return Level(i), nil }
+  }
+  return Info, fmt.Errorf("unknown log level %q", s)
+}
+
+type Fields map[string]interface{}
+
+type Logger interface {
+  Log(level Level, msg string, fields Fields)
+}
+
+/* Merge field sets, later ones taking precedence. */
+func Merge(sets ...Fields) Fields {
+  var res = make(Fields)
+  for _, fields := range sets {
+    for k, v := range fields {
+      res[k] = v
+    }
+  }
+  return res
+}
+
+/* Logger that drops everything. */
+type Discard struct{}
+
+func (Discard) Log(level Level, msg string, fields Fields) {}
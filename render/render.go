@@ -0,0 +1,119 @@
+/*
+  Draw the state of a task1 game in the terminal: the map, with the cells
+  owned by each player and the players' positions, followed by a table of
+  the players' scores.
+
+  The state is read from the block's state.json as api.TaskState, which
+  documents the fields used.  The tests render the state.json files of
+  testdata and compare them with the .txt files of the same name: copy one
+  from a store (blocks/ROUND/state.json) there, with its expected
+  rendering, to check the schema and the renderer against it.
+*/
+
+package render
+
+import (
+  "fmt"
+  "io"
+  "sort"
+  "strings"
+  "github.com/fatih/color"
+  "tezos-contests.izibi.com/tc-node/api"
+)
+
+/* Colors of players 1, 2, ...; ranks beyond the palette wrap around. */
+var palette = []color.Attribute{
+  color.FgRed, color.FgGreen, color.FgYellow, color.FgBlue,
+  color.FgMagenta, color.FgCyan, color.FgHiRed, color.FgHiGreen,
+  color.FgHiYellow, color.FgHiBlue, color.FgHiMagenta, color.FgHiCyan,
+}
+
+var emptyFmt = color.New(color.FgHiBlack)
+var headerFmt = color.New(color.Bold)
+
+type Renderer struct {
+  out io.Writer
+  Ours map[uint32]bool /* ranks of our players, highlighted */
+}
+
+func New(out io.Writer) *Renderer {
+  return &Renderer{out: out}
+}
+
+func playerColor(rank uint32) *color.Color {
+  if rank == 0 { return emptyFmt }
+  return color.New(palette[(rank - 1) % uint32(len(palette))])
+}
+
+/* A player's mark on the map: 1-9, then a-z, then '@'. */
+func playerMark(rank uint32) string {
+  switch {
+  case rank >= 1 && rank <= 9:
+    return string(rune('0' + rank))
+  case rank >= 10 && rank < 36:
+    return string(rune('a' + rank - 10))
+  }
+  return "@"
+}
+
+/* Draw the map (if the state has one) and the players table.  side is the
+   map side from the game parameters, 0 to use the size of the map. */
+func (r *Renderer) Render(state *api.TaskState, side int) {
+  if side == 0 { side = len(state.Map) }
+  if side > 0 && len(state.Map) > 0 {
+    r.renderMap(state, side)
+  }
+  r.renderPlayers(state)
+}
+
+func (r *Renderer) renderMap(state *api.TaskState, side int) {
+  positions := make(map[[2]int]uint32)
+  for _, player := range state.Players {
+    positions[[2]int{player.Row, player.Col}] = player.Rank
+  }
+  border := "+" + strings.Repeat("--", side) + "+"
+  fmt.Fprintln(r.out, border)
+  for row := 0; row < side; row++ {
+    var line strings.Builder
+    line.WriteString("|")
+    for col := 0; col < side; col++ {
+      var owner uint32
+      if row < len(state.Map) && col < len(state.Map[row]) {
+        owner = state.Map[row][col]
+      }
+      if rank, ok := positions[[2]int{row, col}]; ok {
+        c := playerColor(rank).Add(color.Bold)
+        if r.Ours[rank] { c = c.Add(color.ReverseVideo) }
+        line.WriteString(c.Sprint(playerMark(rank) + " "))
+      } else if owner != 0 {
+        line.WriteString(playerColor(owner).Sprint("░░"))
+      } else {
+        line.WriteString(emptyFmt.Sprint(". "))
+      }
+    }
+    line.WriteString("|")
+    fmt.Fprintln(r.out, line.String())
+  }
+  fmt.Fprintln(r.out, border)
+}
+
+func (r *Renderer) renderPlayers(state *api.TaskState) {
+  cells := make(map[uint32]int)
+  for _, row := range state.Map {
+    for _, owner := range row {
+      if owner != 0 { cells[owner] += 1 }
+    }
+  }
+  players := append([]api.PlayerState(nil), state.Players...)
+  sort.SliceStable(players, func (i, j int) bool {
+    return players[i].Score > players[j].Score
+  })
+  headerFmt.Fprintf(r.out, "  %-8s %8s %6s %9s\n", "player", "score", "cells", "position")
+  for _, player := range players {
+    mark := playerColor(player.Rank).Sprint(playerMark(player.Rank))
+    line := fmt.Sprintf("%s %-6d %8d %6d %4d,%-4d", mark, player.Rank, player.Score,
+      cells[player.Rank], player.Row, player.Col)
+    if r.Ours[player.Rank] { line += "  (ours)" }
+    fmt.Fprintf(r.out, "  %s\n", line)
+  }
+}
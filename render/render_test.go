@@ -0,0 +1,93 @@
+
+package render
+
+import (
+  "bytes"
+  "encoding/json"
+  "io/ioutil"
+  "path/filepath"
+  "strings"
+  "testing"
+  "github.com/fatih/color"
+  "tezos-contests.izibi.com/tc-node/api"
+)
+
+func readState(t *testing.T, path string) *api.TaskState {
+  bs, err := ioutil.ReadFile(path)
+  if err != nil { t.Fatal(err) }
+  var state api.TaskState
+  err = json.Unmarshal(bs, &state)
+  if err != nil { t.Fatalf("%s: %v", path, err) }
+  return &state
+}
+
+func TestRender(t *testing.T) {
+  color.NoColor = true
+  state := readState(t, filepath.Join("testdata", "handwritten-state.json"))
+  out := new(bytes.Buffer)
+  r := New(out)
+  r.Ours = map[uint32]bool{2: true}
+  r.Render(state, 3)
+  want := strings.Join([]string{
+    "+------+",
+    "|░░1 . |",
+    "|. ░░░░|",
+    "|. ░░2 |",
+    "+------+",
+    "  player      score  cells  position",
+    "  2 2             7      3    2,2     (ours)",
+    "  1 1             4      3    0,1   ",
+    "",
+  }, "\n")
+  if out.String() != want {
+    t.Errorf("got:\n%s\nwant:\n%s", out.String(), want)
+  }
+}
+
+/* Each state.json of testdata is rendered exactly as in the .txt file of
+   the same name.  A state.json copied from a store (the store keeps one
+   per block, as blocks/ROUND/state.json) is added with the rendering
+   expected from the game's web view. */
+func TestRenderStoredStates(t *testing.T) {
+  color.NoColor = true
+  paths, err := filepath.Glob(filepath.Join("testdata", "*state.json"))
+  if err != nil { t.Fatal(err) }
+  if len(paths) == 0 { t.Fatal("no state.json in testdata") }
+  for _, path := range paths {
+    checkSchema(t, path)
+    state := readState(t, path)
+    want, err := ioutil.ReadFile(strings.TrimSuffix(path, ".json") + ".txt")
+    if err != nil { t.Errorf("%s: %v", path, err); continue }
+    out := new(bytes.Buffer)
+    New(out).Render(state, 0)
+    if out.String() != string(want) {
+      t.Errorf("%s: got:\n%s\nwant:\n%s", path, out.String(), want)
+    }
+  }
+}
+
+/* The players and map of a state.json must have the layout that
+   api.TaskState assumes: decoding ignores unknown fields, so another
+   layout would otherwise render as an empty game. */
+func checkSchema(t *testing.T, path string) {
+  bs, err := ioutil.ReadFile(path)
+  if err != nil { t.Fatal(err) }
+  var raw struct {
+    Players []map[string]json.RawMessage `json:"players"`
+    Map [][]json.RawMessage `json:"map"`
+  }
+  err = json.Unmarshal(bs, &raw)
+  if err != nil { t.Errorf("%s: players or map layout: %v", path, err); return }
+  for i, player := range raw.Players {
+    for _, key := range []string{"rank", "score", "row", "col"} {
+      if _, ok := player[key]; !ok {
+        t.Errorf("%s: player %d has no %q", path, i, key)
+      }
+    }
+  }
+  for i, row := range raw.Map {
+    if len(row) != len(raw.Map) {
+      t.Errorf("%s: map row %d has %d cells, want %d", path, i, len(row), len(raw.Map))
+    }
+  }
+}
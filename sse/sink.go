@@ -6,7 +6,6 @@
 package sse
 
 import (
-  "fmt"
   "bufio"
   "bytes"
   "github.com/go-errors/errors"
@@ -14,10 +13,13 @@ import (
   "net/http"
   "strconv"
   "time"
+  "tezos-contests.izibi.com/tc-node/metrics"
+  "tezos-contests.izibi.com/tc-node/notify"
 )
 
 type client struct {
   uri string
+  logger notify.Logger
   retryDelay time.Duration
   C <-chan string
   ch chan<- string
@@ -25,9 +27,10 @@ type client struct {
   closed bool
 }
 
-func Connect(uri string) (*client, error) {
+func Connect(uri string, logger notify.Logger) (*client, error) {
   ch := make(chan string)
-  c := &client{uri, 3 * time.Second, ch, ch, nil, false}
+  if logger == nil { logger = notify.Discard{} }
+  c := &client{uri, logger, 3 * time.Second, ch, ch, nil, false}
   r, err := c.connect("")
   if err != nil { return nil, err }
   c.closer = r
@@ -54,7 +57,8 @@ func (c *client) connect(lastId string) (io.ReadCloser, error) {
   }
   res, err := http.DefaultClient.Do(req)
   if err != nil {
-    fmt.Printf("SSE client GET failed: %v\n", err)
+    c.logger.Log(notify.Warning, "Event stream connection failed",
+      notify.Fields{"error": err})
     return nil, err
   }
   if res.StatusCode != 200 {
@@ -111,6 +115,8 @@ func (c *client) readEventSource(r io.ReadCloser) {
         r, err = c.connect(lastId)
         if err == nil { break }
       }
+      metrics.SseReconnects.Inc()
+      c.logger.Log(notify.Info, "Event stream reconnected", nil)
       go readLines(r)
       eventType = ""
       dataBuffer.Reset()